@@ -0,0 +1,10 @@
+package api
+
+// LlamaCppTokenizeRequest is the body of llama.cpp server's /tokenize.
+type LlamaCppTokenizeRequest struct {
+	Content string `json:"content"`
+}
+
+type LlamaCppTokenizeResponse struct {
+	Tokens []int `json:"tokens"`
+}
@@ -0,0 +1,75 @@
+package api
+
+// ChatMessage is a message of an OpenAI-style chat completion.
+type ChatMessage struct {
+	Role    ChatRole `json:"role"`
+	Content string   `json:"content"`
+}
+
+type ChatRole string
+
+const (
+	RoleSystem    ChatRole = "system"
+	RoleUser      ChatRole = "user"
+	RoleAssistant ChatRole = "assistant"
+)
+
+// ChatCompletionRequest is the body of /v1/chat/completions.
+type ChatCompletionRequest struct {
+	Model       string        `json:"model,omitempty"`
+	Messages    []ChatMessage `json:"messages"`
+	Stream      bool          `json:"stream"`
+	MaxTokens   uint32        `json:"max_tokens,omitempty"`
+	Temperature float64       `json:"temperature"`
+	TopP        float64       `json:"top_p"`
+	Stop        []string      `json:"stop,omitempty"`
+	Seed        *int64        `json:"seed,omitempty"`
+	// ContinueFinalMessage has servers supporting it (e.g vLLM) carry on with the last
+	// assistant message rather than start a new one
+	ContinueFinalMessage bool  `json:"continue_final_message,omitempty"`
+	AddGenerationPrompt  *bool `json:"add_generation_prompt,omitempty"`
+}
+
+// ChatCompletionResponse is the response of a blocking completion, or a single event of
+// a streamed one where each choice carries a delta instead of the whole message.
+type ChatCompletionResponse struct {
+	Choices []ChatCompletionChoice `json:"choices"`
+	Usage   *ChatCompletionUsage   `json:"usage,omitempty"`
+}
+
+type ChatCompletionUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
+
+type ChatCompletionChoice struct {
+	Message      *ChatMessage `json:"message,omitempty"`
+	Delta        *ChatMessage `json:"delta,omitempty"`
+	FinishReason *string      `json:"finish_reason"`
+}
+
+// ChatCompletionError is the body of a rejected request.
+type ChatCompletionError struct {
+	Error struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// ChatCompletionDone is the data of the event ending a streamed completion.
+const ChatCompletionDone = "[DONE]"
+
+// EmbeddingRequest is the body of /v1/embeddings.
+type EmbeddingRequest struct {
+	Model string   `json:"model,omitempty"`
+	Input []string `json:"input"`
+}
+
+// EmbeddingResponse holds an embedding per input, in the order they were given.
+type EmbeddingResponse struct {
+	Data []EmbeddingData `json:"data"`
+}
+
+type EmbeddingData struct {
+	Index     int       `json:"index"`
+	Embedding []float32 `json:"embedding"`
+}
@@ -1,5 +1,11 @@
 package api
 
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
 type QueryRequest struct {
 	Context    string `json:"context"`
 	Parameters ParameterSet
@@ -24,19 +30,88 @@ type SendInferenceDataRequest struct {
 	EventData   *string     `json:"event_data"`
 }
 
+// QueueJoinRequest submits a job over the SSE queue protocol, where the hash & data
+// are sent up front rather than when the server asks for them.
+type QueueJoinRequest struct {
+	SessionHash string      `json:"session_hash"`
+	FnIndex     uint32      `json:"fn_index"`
+	Data        interface{} `json:"data"`
+	EventData   *string     `json:"event_data"`
+}
+
+type QueueJoinResponse struct {
+	EventId string `json:"event_id"`
+}
+
+// QueueDataRequest sends the data of a job over Gradio 4.0's SSE queue, once the app
+// asks for it on the job's event stream.
+type QueueDataRequest struct {
+	SessionHash string      `json:"session_hash"`
+	FnIndex     uint32      `json:"fn_index"`
+	Data        interface{} `json:"data"`
+	EventData   *string     `json:"event_data"`
+	EventId     string      `json:"event_id"`
+}
+
+// ParameterSet is the generation parameters of text-generation-webui. It is sent to the
+// webui's parameter function as a positional data block, so the order of the fields
+// is that of the function's inputs.
 type ParameterSet struct {
-	MaxNewTokens             uint32 `json:"max_new_tokens"`
-	MaximumPromptTokens      uint32 `json:"maximum_prompt_tokens"`
-	Temperature              uint32 `json:"temperature"`
-	TopP                     uint32 `json:"top_p"`
-	TopK                     uint32 `json:"top_k"`
-	TypicalP                 uint32 `json:"typical_p"`
-	RepetitionPenalty        uint32 `json:"repetition_penalty"`
-	EncoderRepetitionPenalty uint32 `json:"encoder_repetition_penalty"`
-	NoRepeatNgramSize        uint32 `json:"no_repeat_ngram_size"`
-	MinLength                uint32 `json:"min_length"`
-	DoSample                 bool   `json:"do_sample"`
-	Seed                     int64  `json:"seed"`
+	MaxNewTokens             uint32          `json:"max_new_tokens" mapstructure:"max_new_tokens"`
+	Seed                     int64           `json:"seed" mapstructure:"seed"`
+	Temperature              float64         `json:"temperature" mapstructure:"temperature"`
+	TopP                     float64         `json:"top_p" mapstructure:"top_p"`
+	TopK                     uint32          `json:"top_k" mapstructure:"top_k"`
+	TypicalP                 float64         `json:"typical_p" mapstructure:"typical_p"`
+	RepetitionPenalty        float64         `json:"repetition_penalty" mapstructure:"repetition_penalty"`
+	EncoderRepetitionPenalty float64         `json:"encoder_repetition_penalty" mapstructure:"encoder_repetition_penalty"`
+	NoRepeatNgramSize        uint32          `json:"no_repeat_ngram_size" mapstructure:"no_repeat_ngram_size"`
+	MinLength                uint32          `json:"min_length" mapstructure:"min_length"`
+	DoSample                 bool            `json:"do_sample" mapstructure:"do_sample"`
+	PenaltyAlpha             float64         `json:"penalty_alpha" mapstructure:"penalty_alpha"`
+	NumBeams                 uint32          `json:"num_beams" mapstructure:"num_beams"`
+	LengthPenalty            float64         `json:"length_penalty" mapstructure:"length_penalty"`
+	EarlyStopping            bool            `json:"early_stopping" mapstructure:"early_stopping"`
+	AddBosToken              bool            `json:"add_bos_token" mapstructure:"add_bos_token"`
+	StoppingStrings          StoppingStrings `json:"stopping_strings" mapstructure:"stopping_strings"`
+
+	// Only used to size the prompt, it isn't sent to the webui
+	MaximumPromptTokens uint32 `json:"-" mapstructure:"maximum_prompt_tokens"`
+}
+
+// MarshalJSON encodes the parameters positionally, skipping fields tagged json:"-".
+func (p ParameterSet) MarshalJSON() ([]byte, error) {
+	v := reflect.ValueOf(p)
+	t := v.Type()
+	refValues := []interface{}{}
+
+	for i := 0; i < v.NumField(); i++ {
+		if t.Field(i).Tag.Get("json") == "-" {
+			continue
+		}
+
+		refValues = append(refValues, v.Field(i).Interface())
+	}
+
+	return json.Marshal(refValues)
+}
+
+// StoppingStrings are strings which end generation once produced. The webui takes them
+// as a single string of comma separated, quoted strings.
+type StoppingStrings []string
+
+func (s StoppingStrings) MarshalJSON() ([]byte, error) {
+	quoted := []string{}
+	for _, str := range s {
+		q, err := json.Marshal(str)
+		if err != nil {
+			return nil, err
+		}
+
+		quoted = append(quoted, string(q))
+	}
+
+	return json.Marshal(strings.Join(quoted, ", "))
 }
 
 type GradioResponsePacket struct {
@@ -62,4 +137,12 @@ const (
 	MsgProcessStarts     GradioResponseMessage = "process_starts"
 	MsgProcessGenerating GradioResponseMessage = "process_generating"
 	MsgProcessCompleted  GradioResponseMessage = "process_completed"
+
+	MsgQueueFull GradioResponseMessage = "queue_full"
+	MsgLog       GradioResponseMessage = "log"
+
+	// Only sent over the SSE queue protocol
+	MsgHeartbeat       GradioResponseMessage = "heartbeat"
+	MsgCloseStream     GradioResponseMessage = "close_stream"
+	MsgUnexpectedError GradioResponseMessage = "unexpected_error"
 )
@@ -0,0 +1,90 @@
+package api
+
+// WebuiGenerateRequest is the body of text-generation-webui's /api/v1/generate, also
+// sent as the first message over /api/v1/stream.
+type WebuiGenerateRequest struct {
+	Prompt                   string   `json:"prompt"`
+	MaxNewTokens             uint32   `json:"max_new_tokens"`
+	Seed                     int64    `json:"seed"`
+	Temperature              float64  `json:"temperature"`
+	TopP                     float64  `json:"top_p"`
+	TopK                     uint32   `json:"top_k"`
+	TypicalP                 float64  `json:"typical_p"`
+	RepetitionPenalty        float64  `json:"repetition_penalty"`
+	EncoderRepetitionPenalty float64  `json:"encoder_repetition_penalty"`
+	NoRepeatNgramSize        uint32   `json:"no_repeat_ngram_size"`
+	MinLength                uint32   `json:"min_length"`
+	DoSample                 bool     `json:"do_sample"`
+	PenaltyAlpha             float64  `json:"penalty_alpha"`
+	NumBeams                 uint32   `json:"num_beams"`
+	LengthPenalty            float64  `json:"length_penalty"`
+	EarlyStopping            bool     `json:"early_stopping"`
+	AddBosToken              bool     `json:"add_bos_token"`
+	TruncationLength         uint32   `json:"truncation_length"`
+	StoppingStrings          []string `json:"stopping_strings"`
+}
+
+// NewWebuiGenerateRequest builds a request for prompt with the generation parameters p.
+func NewWebuiGenerateRequest(prompt string, p *ParameterSet) WebuiGenerateRequest {
+	stoppingStrings := p.StoppingStrings
+	if stoppingStrings == nil {
+		stoppingStrings = []string{}
+	}
+
+	return WebuiGenerateRequest{
+		Prompt:                   prompt,
+		MaxNewTokens:             p.MaxNewTokens,
+		Seed:                     p.Seed,
+		Temperature:              p.Temperature,
+		TopP:                     p.TopP,
+		TopK:                     p.TopK,
+		TypicalP:                 p.TypicalP,
+		RepetitionPenalty:        p.RepetitionPenalty,
+		EncoderRepetitionPenalty: p.EncoderRepetitionPenalty,
+		NoRepeatNgramSize:        p.NoRepeatNgramSize,
+		MinLength:                p.MinLength,
+		DoSample:                 p.DoSample,
+		PenaltyAlpha:             p.PenaltyAlpha,
+		NumBeams:                 p.NumBeams,
+		LengthPenalty:            p.LengthPenalty,
+		EarlyStopping:            p.EarlyStopping,
+		AddBosToken:              p.AddBosToken,
+		TruncationLength:         p.MaximumPromptTokens,
+		StoppingStrings:          stoppingStrings,
+	}
+}
+
+type WebuiGenerateResponse struct {
+	Results []WebuiResult `json:"results"`
+}
+
+type WebuiResult struct {
+	Text string `json:"text"`
+}
+
+// WebuiStreamPacket is a message received over /api/v1/stream.
+type WebuiStreamPacket struct {
+	Event      WebuiStreamEvent `json:"event"`
+	MessageNum int              `json:"message_num"`
+	Text       string           `json:"text"`
+}
+
+type WebuiStreamEvent string
+
+const (
+	WebuiTextStream WebuiStreamEvent = "text_stream"
+	WebuiStreamEnd  WebuiStreamEvent = "stream_end"
+)
+
+// WebuiTokenCountRequest is the body of text-generation-webui's /api/v1/token-count.
+type WebuiTokenCountRequest struct {
+	Prompt string `json:"prompt"`
+}
+
+type WebuiTokenCountResponse struct {
+	Results []WebuiTokenCount `json:"results"`
+}
+
+type WebuiTokenCount struct {
+	Tokens int `json:"tokens"`
+}
@@ -0,0 +1,41 @@
+package fakebackend
+
+import (
+	"net/http"
+	"os"
+
+	"github.com/M-Ro/aurora-ai/internal/gradio/fake"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func NewCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "fake-backend",
+		Short: "serve a fake Gradio app standing in for the llm & stable diffusion backends",
+		Run:   Start,
+	}
+
+	cmd.Flags().String("listen", "127.0.0.1:7860", "address to serve on")
+	cmd.Flags().String("gradio-version", "3.28.0", "Gradio version to report, 4 and up serve the SSE queue")
+	cmd.Flags().Duration("interval", 0, "delay between queue packets")
+
+	return cmd
+}
+
+// Start the fake backend
+func Start(cmd *cobra.Command, _ []string) {
+	listen, _ := cmd.Flags().GetString("listen")
+	version, _ := cmd.Flags().GetString("gradio-version")
+	interval, _ := cmd.Flags().GetDuration("interval")
+
+	server := fake.NewDemoServer(version, interval)
+
+	log.Infof("Serving fake Gradio %s app on %s", version, listen)
+
+	err := http.ListenAndServe(listen, server.Handler())
+	if err != nil {
+		log.Error(err)
+		os.Exit(1)
+	}
+}
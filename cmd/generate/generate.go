@@ -0,0 +1,64 @@
+package generate
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/M-Ro/aurora-ai/internal/textgen"
+	chat "github.com/M-Ro/aurora-ai/internal/textgen/context"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func NewCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "generate [message]",
+		Short: "generate the bot's reply to a message from the terminal",
+		Args:  cobra.MinimumNArgs(1),
+		Run:   Start,
+	}
+
+	cmd.Flags().String("generator", textgen.DefaultGenerator, "name of the generator to use")
+
+	return cmd
+}
+
+// Start generates a reply, streaming it to stdout
+func Start(cmd *cobra.Command, args []string) {
+	name, _ := cmd.Flags().GetString("generator")
+
+	generator, err := textgen.GetGenerator(name)
+	if err != nil {
+		log.Error(err)
+		os.Exit(1)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	chatCtx := &chat.ChatContext{}
+	chatCtx.AddMessage(&chat.ContextMessage{
+		Author:  chat.Author{Id: "cli", Name: "cli"},
+		Message: strings.Join(args, " "),
+	})
+
+	usage, err := generator.Generate(ctx, &textgen.Job{
+		SessionKey: "cli",
+		Context:    chatCtx,
+		OnDelta: func(delta string, reply *textgen.Reply) {
+			fmt.Print(delta)
+		},
+		OnComplete: func(output string) {
+			fmt.Println()
+		},
+	})
+	if err != nil {
+		log.Error(err)
+		os.Exit(1)
+	}
+
+	log.Infof("%d prompt & %d completion tokens", usage.PromptTokens, usage.CompletionTokens)
+}
@@ -1,11 +1,17 @@
 package instance
 
 import (
+	"context"
+	"errors"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/M-Ro/aurora-ai/internal/discord"
+	"github.com/M-Ro/aurora-ai/internal/gradio"
+	"github.com/M-Ro/aurora-ai/internal/stablediffusion"
+	"github.com/M-Ro/aurora-ai/internal/textgen"
 	"github.com/bwmarrin/discordgo"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -30,6 +36,11 @@ func Start(_ *cobra.Command, _ []string) {
 		os.Exit(1)
 	}
 
+	sessions := gradio.GetSessionStore()
+	log.Infof("Restored %d Gradio sessions", sessions.Len())
+
+	resolveEndpoints()
+
 	registerEventHandlers(dg)
 	discord.RegisterSlashCommands(dg)
 
@@ -49,6 +60,34 @@ func Start(_ *cobra.Command, _ []string) {
 	dg.Close()
 }
 
+// resolveEndpoints maps the configured Gradio endpoints of each backend to fn indices,
+// backends without any hosts have nothing to resolve. An unreachable backend is
+// resolved again on first use, but one which no longer exposes an endpoint we need
+// is fatal.
+func resolveEndpoints() {
+	backends := map[string]func(context.Context) error{
+		"llm":              textgen.ResolveEndpoints,
+		"stable_diffusion": stablediffusion.ResolveEndpoints,
+	}
+
+	for name, resolve := range backends {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		err := resolve(ctx)
+		cancel()
+
+		if err == nil {
+			continue
+		}
+
+		var missing *gradio.MissingEndpointsError
+		if errors.As(err, &missing) {
+			log.Fatal(err)
+		}
+
+		log.Warnf("Could not resolve %s endpoints: %s", name, err)
+	}
+}
+
 func registerEventHandlers(dg *discordgo.Session) {
 	dg.AddHandler(discord.OnReady)
 	dg.AddHandler(discord.OnMessageCreate)
@@ -2,6 +2,8 @@ package main
 
 import (
 	"fmt"
+	"github.com/M-Ro/aurora-ai/cmd/fakebackend"
+	"github.com/M-Ro/aurora-ai/cmd/generate"
 	"github.com/M-Ro/aurora-ai/cmd/instance"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -12,6 +14,8 @@ var rootCmd = &cobra.Command{}
 
 func init() {
 	rootCmd.AddCommand(instance.NewCmd())
+	rootCmd.AddCommand(fakebackend.NewCmd())
+	rootCmd.AddCommand(generate.NewCmd())
 }
 
 // initialises viper config library.
@@ -34,7 +38,7 @@ func main() {
 
 	err := rootCmd.Execute()
 	if err != nil {
-		fmt.Println(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 }
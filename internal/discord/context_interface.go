@@ -2,8 +2,10 @@ package discord
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 
+	"github.com/M-Ro/aurora-ai/internal/textgen/character"
 	"github.com/M-Ro/aurora-ai/internal/textgen/context"
 	"github.com/bwmarrin/discordgo"
 )
@@ -14,11 +16,48 @@ func NewCtxMsgFromDiscordMsg(s *discordgo.Session, msg *discordgo.MessageCreate)
 	token := fmt.Sprintf("@%s ", s.State.User.Username)
 	queryContent := strings.Replace(msg.ContentWithMentionsReplaced(), token, "", -1)
 
+	// Go by the name the author shows as in the guild
+	name := msg.Author.Username
+	if msg.Member != nil && msg.Member.Nick != "" {
+		name = msg.Member.Nick
+	}
+
 	return context.ContextMessage{
+		Id: msg.ID,
 		Author: context.Author{
 			Id:   msg.Author.ID,
-			Name: msg.Author.Username,
+			Name: name,
 		},
 		Message: queryContent,
 	}
 }
+
+// mentionSpeakers turns the bot addressing users as @name into discord mentions of them.
+func mentionSpeakers(text string, chatCtx *context.ChatContext) string {
+	names := map[string]string{}
+	for _, ctxMsg := range chatCtx.Messages {
+		names[ctxMsg.Author.Name] = ctxMsg.Author.Id
+	}
+
+	// Names as labelled in the prompt win, the bot is going by those
+	for id, name := range chatCtx.Speakers() {
+		names[name] = id
+	}
+
+	// The replacer tries pairs in order, so longer names go first lest "@Alex" cuts
+	// "@Alex (2)" short
+	sorted := []string{}
+	for name, id := range names {
+		if name != "" && id != character.BotToken() {
+			sorted = append(sorted, name)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+
+	pairs := []string{}
+	for _, name := range sorted {
+		pairs = append(pairs, "@"+name, "<@"+names[name]+">")
+	}
+
+	return strings.NewReplacer(pairs...).Replace(text)
+}
@@ -0,0 +1,55 @@
+package discord
+
+import (
+	"github.com/M-Ro/aurora-ai/internal/textgen/context"
+	"github.com/bwmarrin/discordgo"
+)
+
+// onContinueButton continues the reply the Continue button is under.
+func onContinueButton(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	reworkButton(s, i, continuing)
+}
+
+// onContinueCommand continues the bot's last reply in the channel.
+func onContinueCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	chatCtx := context.GetContext(i.ChannelID)
+	chatCtx.Lock()
+	messageID := ""
+	if index := chatCtx.LastReply(); index >= 0 {
+		messageID = chatCtx.Messages[index].Id
+	}
+	chatCtx.Unlock()
+
+	if messageID == "" {
+		respondEphemeral(s, i, "There is no reply of mine to continue here.")
+		return
+	}
+
+	claimed, release := claimReply(messageID)
+	if !claimed {
+		respondEphemeral(s, i, "That reply is already being worked on.")
+		return
+	}
+	defer release()
+
+	respondEphemeral(s, i, "Continuing my last reply.")
+
+	rework(s, i, chatCtx, messageID, continuing)
+}
+
+// continuing has the model carry on with a reply from where it left off, appending
+// what it generates to it.
+var continuing = replyRework{
+	name: "Continuing",
+	prompt: func(chatCtx *context.ChatContext, index int) *context.ChatContext {
+		// The reply is the last message of the prompt, left open for the model to continue
+		snapshot := chatCtx.Before(index + 1)
+		snapshot.Open = true
+
+		return snapshot
+	},
+	text: func(reply context.ContextMessage, output string) string {
+		return reply.Message + output
+	},
+	apply: (*context.ContextMessage).Extend,
+}
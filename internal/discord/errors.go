@@ -0,0 +1,55 @@
+package discord
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/M-Ro/aurora-ai/internal/gradio"
+	"github.com/sirupsen/logrus"
+)
+
+// maxRetries is how many times a job failing with a retryable error is resubmitted.
+const maxRetries = 2
+
+// errorMessage describes why a backend job failed to the user.
+func errorMessage(err error) string {
+	var upstream *gradio.UpstreamError
+	var protocol *gradio.ProtocolError
+	var unexpected *gradio.UnexpectedPacketError
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return "Timed out waiting for a response."
+	case errors.Is(err, gradio.ErrQueueFull):
+		return "The backend is too busy right now, try again shortly."
+	case errors.As(err, &upstream):
+		return fmt.Sprintf("The backend failed: %s", upstream.Message)
+	case errors.As(err, &protocol), errors.As(err, &unexpected):
+		return "The backend sent a response I couldn't understand."
+	case gradio.IsRetryable(err):
+		return "Couldn't reach the backend."
+	}
+
+	return fmt.Sprintf("Something went wrong: %s", err)
+}
+
+// withRetries runs job, running it again after a short wait while it fails with an
+// error which may go away on its own, such as a full queue.
+func withRetries(ctx context.Context, job func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := job()
+		if err == nil || attempt > maxRetries || !gradio.IsRetryable(err) {
+			return err
+		}
+
+		logrus.Warnf("Job failed, retrying (%d/%d): %s", attempt, maxRetries, err)
+
+		select {
+		case <-time.After(time.Duration(attempt) * 2 * time.Second):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
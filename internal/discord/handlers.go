@@ -1,10 +1,13 @@
 package discord
 
 import (
+	"strings"
 	"time"
 
+	"github.com/M-Ro/aurora-ai/internal/gradio"
 	"github.com/M-Ro/aurora-ai/internal/textgen"
 	"github.com/M-Ro/aurora-ai/internal/textgen/context"
+	"github.com/M-Ro/aurora-ai/internal/textgen/recall"
 	"github.com/bwmarrin/discordgo"
 	"github.com/sirupsen/logrus"
 )
@@ -44,79 +47,139 @@ func OnMessageCreate(s *discordgo.Session, msg *discordgo.MessageCreate) {
 func respond(s *discordgo.Session, msg *discordgo.MessageCreate) {
 	lastTime := time.Now().UnixMilli()
 
-	// Get the chat ctx for this channel, build & append a new ctx msg from the discord msg
+	generator, err := textgen.GeneratorFor(msg.GuildID, msg.ChannelID)
+	if err != nil {
+		logrus.Error("Failed to pick a generator: ", err)
+		return
+	}
+
+	// Get the chat ctx for this channel, build & append a new ctx msg from the discord msg.
+	// It's sized in the layout of the prompt the generator is sent.
 	chatCtx := context.GetContext(msg.ChannelID)
 	ctxMsg := NewCtxMsgFromDiscordMsg(s, msg)
-	err := chatCtx.AddMessage(&ctxMsg)
+	chatCtx.Lock()
+	chatCtx.Format = textgen.FormatOf(generator)
+	err = chatCtx.AddMessage(&ctxMsg)
+	chatCtx.Unlock()
 	if err != nil {
 		logrus.Error("Failed to add message to chat context.", err)
 		return
 	}
+	remember(msg.ChannelID, ctxMsg)
+
+	jobCtx, cancel := newJobContext("llm")
+	defer cancel()
+
+	// Messages which no longer fit are summarized into the conversation's memory first
+	err = textgen.Summarize(jobCtx, generator, msg.ChannelID, chatCtx)
+	if err != nil {
+		logrus.Warn("Summarizing the conversation failed: ", err)
+	}
+
+	// Past exchanges relevant to the message are recalled from long-term memory
+	_, err = recall.Recall(msg.ChannelID, chatCtx)
+	if err != nil {
+		logrus.Warn("Recalling past exchanges failed: ", err)
+	}
+
+	// Other messages may come in while we generate, so the reply is to the conversation
+	// as it is now
+	chatCtx.Lock()
+	snapshot := chatCtx.Snapshot()
+	chatCtx.Unlock()
 
 	// Run inference, update discord message as we get new tokens.
+	// While we wait in the queue, the message shows our place in it instead.
 	var sendMsg *discordgo.Message
-	err = textgen.RunInference(
-		chatCtx.Prompt(), // Send the entire compiled conversation prompt to the inferencer
-		func(output string) {
-			if len(output) <= 0 {
-				return
-			}
-
-			ctxBotResponseMsg := context.NewCtxMsgFromBotResponse(output)
-			if len(ctxBotResponseMsg.Message) <= 0 {
-				return
-			}
-
-			if sendMsg == nil {
-				sendMsg, err = s.ChannelMessageSend(msg.ChannelID, ctxBotResponseMsg.Message)
-				if err != nil {
-					logrus.Error("fek", err)
+	var usage textgen.Usage
+	generating := false
+	err = withRetries(jobCtx, func() error {
+		usage, err = generator.Generate(jobCtx, &textgen.Job{
+			SessionKey: msg.ChannelID,
+			Context:    snapshot, // Send the entire conversation to the inferencer
+			OnQueue: func(est gradio.Estimation) {
+				if generating {
 					return
 				}
-			} else {
-				if time.Now().UnixMilli() > lastTime+750 {
+
+				status := queueStatusMessage(est)
+				if sendMsg == nil {
+					lastTime = time.Now().UnixMilli()
+					sendMsg, err = s.ChannelMessageSend(msg.ChannelID, status)
+					if err != nil {
+						logrus.Error("fek", err)
+					}
+				} else if time.Now().UnixMilli() > lastTime+queueStatusInterval.Milliseconds() {
 					lastTime = time.Now().UnixMilli()
-					sendMsg, err = s.ChannelMessageEdit(msg.ChannelID, sendMsg.Reference().MessageID, ctxBotResponseMsg.Message)
+					sendMsg, err = s.ChannelMessageEdit(msg.ChannelID, sendMsg.Reference().MessageID, status)
+					if err != nil {
+						logrus.Error("fek", err)
+					}
+				}
+			},
+			OnDelta: func(delta string, reply *textgen.Reply) {
+				// Whatever doesn't fit in the message is spilled into more once complete
+				text := splitMessage(mentionSpeakers(strings.TrimSpace(reply.Text()), snapshot))[0]
+				if len(text) <= 0 {
+					return
+				}
+				generating = true
+
+				if sendMsg == nil {
+					sendMsg, err = s.ChannelMessageSend(msg.ChannelID, text)
 					if err != nil {
 						logrus.Error("fek", err)
 						return
 					}
+				} else {
+					if time.Now().UnixMilli() > lastTime+750 {
+						lastTime = time.Now().UnixMilli()
+						sendMsg, err = s.ChannelMessageEdit(msg.ChannelID, sendMsg.Reference().MessageID, text)
+						if err != nil {
+							logrus.Error("fek", err)
+							return
+						}
+					}
 				}
-			}
-		},
-		func(output string) {
-			if len(output) <= 0 {
-				return
-			}
-
-			ctxBotResponseMsg := context.NewCtxMsgFromBotResponse(output)
-
-			if sendMsg != nil {
-				sendMsg, err = s.ChannelMessageEdit(msg.ChannelID, sendMsg.Reference().MessageID, ctxBotResponseMsg.Message)
-				if err != nil {
-					logrus.Error("fek", err)
+			},
+			OnComplete: func(output string) {
+				if len(output) <= 0 {
 					return
 				}
 
-				// Add the message to the convo prompt
-				chatCtx.AddMessage(&ctxBotResponseMsg)
-			}
-		},
-	)
-}
+				ctxBotResponseMsg := context.NewCtxMsgFromBotResponse(output)
 
-// TODO reimplement this
-func cmdResetConversation(s *discordgo.Session, msg *discordgo.MessageCreate) {
-	chatCtx := context.GetContext(msg.ChannelID)
+				if sendMsg != nil {
+					// The reply gets the buttons to regenerate it with
+					ctxBotResponseMsg.Id = sendMsg.ID
+					err = showReply(s, msg.ChannelID, &ctxBotResponseMsg, mentionSpeakers(ctxBotResponseMsg.Message, snapshot))
+					if err != nil {
+						logrus.Error("fek", err)
+						return
+					}
 
-	if chatCtx == nil {
-		return
-	}
+					// Add the message to the convo prompt
+					chatCtx.Lock()
+					chatCtx.AddMessage(&ctxBotResponseMsg)
+					chatCtx.Unlock()
+					remember(msg.ChannelID, ctxBotResponseMsg)
+				}
+			},
+		})
 
-	chatCtx.Messages = []context.ContextMessage{}
+		return err
+	})
 
-	_, err := s.ChannelMessageSend(msg.ChannelID, "Conversation has been reset.")
 	if err != nil {
-		logrus.Error("fek", err)
+		logrus.Error("Inference failed: ", err)
+
+		_, err = s.ChannelMessageSend(msg.ChannelID, errorMessage(err))
+		if err != nil {
+			logrus.Error("fek", err)
+		}
+
+		return
 	}
+
+	logrus.Debugf("Reply took %d prompt & %d completion tokens", usage.PromptTokens, usage.CompletionTokens)
 }
@@ -0,0 +1,18 @@
+package discord
+
+import (
+	"context"
+
+	"github.com/spf13/viper"
+)
+
+// newJobContext returns the context a backend job runs under, bounded by the timeout
+// configured for the backend, e.g llm.timeout, if there is one.
+func newJobContext(backend string) (context.Context, context.CancelFunc) {
+	timeout := viper.GetDuration(backend + ".timeout")
+	if timeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+
+	return context.WithTimeout(context.Background(), timeout)
+}
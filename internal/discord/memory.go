@@ -0,0 +1,83 @@
+package discord
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/M-Ro/aurora-ai/internal/textgen/context"
+	"github.com/M-Ro/aurora-ai/internal/textgen/recall"
+	"github.com/bwmarrin/discordgo"
+	"github.com/sirupsen/logrus"
+)
+
+// onSummaryCommand shows the summary of the channel's conversation to whoever asked.
+func onSummaryCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	chatCtx := context.GetContext(i.ChannelID)
+	chatCtx.Lock()
+	summary := chatCtx.Summary
+	chatCtx.Unlock()
+
+	switch {
+	case !context.Remembers():
+		respondEphemeral(s, i, "Memory is turned off, I forget messages once they no longer fit.")
+	case summary == "":
+		respondEphemeral(s, i, "Nothing has been summarized yet, the whole conversation still fits.")
+	default:
+		respondEphemeral(s, i, splitMessage(summary)[0])
+	}
+}
+
+// onRecallCommand shows whoever asked the exchanges recalled for the bot's last reply,
+// or those of the channel's long-term memory matching the query given.
+func onRecallCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if !context.Recalls() {
+		respondEphemeral(s, i, "Long-term memory is turned off.")
+		return
+	}
+
+	count, embedder, err := recall.Stats(i.ChannelID)
+	if err != nil {
+		logrus.Error("fek", err)
+		respondEphemeral(s, i, "My long-term memory of this channel can't be read right now.")
+		return
+	}
+
+	query := ""
+	for _, option := range i.ApplicationCommandData().Options {
+		if option.Name == "query" {
+			query = option.StringValue()
+		}
+	}
+
+	recollections := recall.Recalled(i.ChannelID)
+	empty := "Nothing was recalled for my last reply."
+	if query != "" {
+		recollections, err = recall.Search(i.ChannelID, query)
+		if err != nil {
+			logrus.Error("fek", err)
+			respondEphemeral(s, i, "Searching my long-term memory failed.")
+			return
+		}
+		empty = "Nothing I remember matches that."
+	}
+
+	text := fmt.Sprintf("I remember %d messages from this channel, embedded with %s.\n\n", count, embedder)
+	if len(recollections) == 0 {
+		text += empty
+	}
+
+	for _, recollection := range recollections {
+		text += fmt.Sprintf("**%.2f** messages %d-%d\n> %s\n\n", recollection.Score, recollection.First, recollection.Last,
+			strings.ReplaceAll(recollection.Text, "\n", "\n> "))
+	}
+
+	respondEphemeral(s, i, splitMessage(strings.TrimSpace(text))[0])
+}
+
+// remember stores the message in the channel's long-term memory.
+func remember(channelID string, ctxMsg context.ContextMessage) {
+	err := recall.Remember(channelID, ctxMsg)
+	if err != nil {
+		logrus.Warn("Storing the message in long-term memory failed: ", err)
+	}
+}
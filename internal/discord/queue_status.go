@@ -0,0 +1,23 @@
+package discord
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/M-Ro/aurora-ai/internal/gradio"
+)
+
+// queueStatusInterval limits how often a message is edited with queue updates.
+const queueStatusInterval = 2 * time.Second
+
+// queueStatusMessage describes our place in a backend's queue to the user,
+// e.g "You are #3 in queue, ~40s".
+func queueStatusMessage(est gradio.Estimation) string {
+	msg := fmt.Sprintf("You are #%d in queue", est.Rank+1)
+
+	if est.RankEta > 0 {
+		msg += fmt.Sprintf(", ~%ds", int(est.RankEta.Round(time.Second).Seconds()))
+	}
+
+	return msg
+}
@@ -0,0 +1,92 @@
+package discord
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/M-Ro/aurora-ai/internal/textgen/context"
+	"github.com/bwmarrin/discordgo"
+)
+
+// maxMessageLength is the most characters discord allows in a message.
+const maxMessageLength = 2000
+
+var (
+	// overflow holds the messages a reply spilled into, keyed by the reply's message id
+	overflow   = map[string][]string{}
+	overflowMu sync.Mutex
+)
+
+// splitMessage splits text into parts short enough for a discord message each, breaking
+// at a line or word where it can.
+func splitMessage(text string) []string {
+	parts := []string{}
+
+	runes := []rune(text)
+	for len(runes) > maxMessageLength {
+		end := maxMessageLength
+		if i := strings.LastIndexAny(string(runes[:end]), "\n "); i > 0 {
+			end = len([]rune(string(runes[:end])[:i]))
+		}
+
+		parts = append(parts, strings.TrimSpace(string(runes[:end])))
+		runes = []rune(strings.TrimLeft(string(runes[end:]), " \n"))
+	}
+
+	return append(parts, string(runes))
+}
+
+// showReply shows text in the reply's message, spilling whatever doesn't fit into
+// messages after it. Spilled messages no longer needed are deleted.
+func showReply(s *discordgo.Session, channelID string, ctxMsg *context.ContextMessage, text string) error {
+	parts := splitMessage(text)
+
+	_, err := s.ChannelMessageEditComplex(&discordgo.MessageEdit{
+		ID:         ctxMsg.Id,
+		Channel:    channelID,
+		Content:    &parts[0],
+		Components: replyButtons(ctxMsg),
+	})
+	if err != nil {
+		return err
+	}
+
+	overflowMu.Lock()
+	spilled := overflow[ctxMsg.Id]
+	overflowMu.Unlock()
+
+	kept := []string{}
+	for n, part := range parts[1:] {
+		if n < len(spilled) {
+			_, err = s.ChannelMessageEdit(channelID, spilled[n], part)
+			if err != nil {
+				return err
+			}
+
+			kept = append(kept, spilled[n])
+			continue
+		}
+
+		msg, err := s.ChannelMessageSend(channelID, part)
+		if err != nil {
+			return err
+		}
+
+		kept = append(kept, msg.ID)
+	}
+
+	if len(spilled) > len(kept) {
+		for _, id := range spilled[len(kept):] {
+			err = s.ChannelMessageDelete(channelID, id)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	overflowMu.Lock()
+	overflow[ctxMsg.Id] = kept
+	overflowMu.Unlock()
+
+	return nil
+}
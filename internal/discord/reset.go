@@ -0,0 +1,35 @@
+package discord
+
+import (
+	"github.com/M-Ro/aurora-ai/internal/gradio"
+	"github.com/M-Ro/aurora-ai/internal/textgen/context"
+	"github.com/M-Ro/aurora-ai/internal/textgen/recall"
+	"github.com/bwmarrin/discordgo"
+	"github.com/sirupsen/logrus"
+)
+
+// onResetCommand starts the channel's conversation afresh, forgetting everything said.
+func onResetCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	chatCtx := context.GetContext(i.ChannelID)
+	chatCtx.Lock()
+	chatCtx.Reset()
+	chatCtx.Unlock()
+
+	err := recall.Forget(i.ChannelID)
+	if err != nil {
+		logrus.Error("Failed to forget the channel's long-term memory: ", err)
+	}
+
+	// Drop the server side history along with ours
+	gradio.GetSessionStore().Reset(i.ChannelID)
+
+	err = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: "Conversation has been reset.",
+		},
+	})
+	if err != nil {
+		logrus.Error("fek", err)
+	}
+}
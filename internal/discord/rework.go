@@ -0,0 +1,138 @@
+package discord
+
+import (
+	"strings"
+	"time"
+
+	"github.com/M-Ro/aurora-ai/internal/textgen"
+	"github.com/M-Ro/aurora-ai/internal/textgen/context"
+	"github.com/bwmarrin/discordgo"
+	"github.com/sirupsen/logrus"
+)
+
+// replyRework is a way of having the model work on one of its replies again, as the
+// Regenerate and Continue buttons do.
+type replyRework struct {
+	// name is what failed in the log, e.g "Regenerating"
+	name string
+	// prompt returns the conversation to generate from for the reply at index
+	prompt func(chatCtx *context.ChatContext, index int) *context.ChatContext
+	// text returns the reply as shown while the output streams in
+	text func(reply context.ContextMessage, output string) string
+	// apply updates the reply with the complete output
+	apply func(reply *context.ContextMessage, output string)
+}
+
+// reworkButton works on the reply the button pressed is under again.
+func reworkButton(s *discordgo.Session, i *discordgo.InteractionCreate, how replyRework) {
+	chatCtx := context.GetContext(i.ChannelID)
+	chatCtx.Lock()
+	found := chatCtx.Find(i.Message.ID) >= 0
+	chatCtx.Unlock()
+
+	if !found {
+		respondEphemeral(s, i, "That reply is no longer part of the conversation.")
+		return
+	}
+
+	claimed, release := claimReply(i.Message.ID)
+	if !claimed {
+		respondEphemeral(s, i, "That reply is already being worked on.")
+		return
+	}
+	defer release()
+
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredMessageUpdate,
+	})
+	if err != nil {
+		logrus.Error("fek", err)
+		return
+	}
+
+	rework(s, i, chatCtx, i.Message.ID, how)
+}
+
+// rework has the model work on the reply with the id again, streaming it into the
+// reply's message. The reply is updated with what it generates once done, which is
+// remembered and shown.
+func rework(s *discordgo.Session, i *discordgo.InteractionCreate, chatCtx *context.ChatContext, messageID string, how replyRework) {
+	generator, err := textgen.GeneratorFor(i.GuildID, i.ChannelID)
+	if err != nil {
+		logrus.Error("Failed to pick a generator: ", err)
+		return
+	}
+
+	jobCtx, cancel := newJobContext("llm")
+	defer cancel()
+
+	chatCtx.Lock()
+	index := chatCtx.Find(messageID)
+	if index < 0 {
+		chatCtx.Unlock()
+		return
+	}
+	snapshot := how.prompt(chatCtx, index)
+	streaming := chatCtx.Messages[index]
+	chatCtx.Unlock()
+
+	lastTime := time.Now().UnixMilli()
+	output := ""
+
+	err = withRetries(jobCtx, func() error {
+		_, err := generator.Generate(jobCtx, &textgen.Job{
+			SessionKey: i.ChannelID,
+			Context:    snapshot,
+			OnDelta: func(delta string, reply *textgen.Reply) {
+				text := mentionSpeakers(strings.TrimSpace(how.text(streaming, reply.Text())), snapshot)
+				if len(text) <= 0 || time.Now().UnixMilli() <= lastTime+750 {
+					return
+				}
+
+				lastTime = time.Now().UnixMilli()
+				err := showReply(s, i.ChannelID, &streaming, text)
+				if err != nil {
+					logrus.Error("fek", err)
+				}
+			},
+			OnComplete: func(reply string) {
+				output = reply
+			},
+		})
+
+		return err
+	})
+
+	if err != nil {
+		logrus.Error(how.name+" failed: ", err)
+		respondFollowup(s, i, errorMessage(err))
+	}
+
+	// The conversation may have moved on meanwhile, so look the reply up again
+	chatCtx.Lock()
+	index = chatCtx.Find(messageID)
+	if index < 0 {
+		chatCtx.Unlock()
+		return
+	}
+
+	ctxMsg := &chatCtx.Messages[index]
+	if err == nil && strings.TrimSpace(output) != "" {
+		how.apply(ctxMsg, output)
+	}
+
+	shown := *ctxMsg
+	text := mentionSpeakers(shown.Message, chatCtx)
+	chatCtx.EnforceSize()
+	chatCtx.Unlock()
+
+	if err == nil {
+		remember(i.ChannelID, shown)
+	}
+
+	// Put back the reply as it was should it have failed
+	err = showReply(s, i.ChannelID, &shown, text)
+	if err != nil {
+		logrus.Error("fek", err)
+	}
+}
@@ -15,8 +15,41 @@ var (
 			Name:        "generate",
 			Description: "Generate an image from a prompt via stable diffusion",
 		},
+		{
+			Name:        "continue",
+			Description: "Continue my last reply from where it stopped",
+		},
+		{
+			Name:        "reset",
+			Description: "Start our conversation in this channel afresh",
+		},
+		{
+			Name:        "summary",
+			Description: "Show what I remember of this conversation beyond its last messages",
+		},
+		{
+			Name:        "recall",
+			Description: "Show the past exchanges I recalled for my last reply, or those matching a query",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "query",
+					Description: "Search my long-term memory of this channel instead",
+				},
+			},
+		},
+	}
+	componentHandlers = map[string]func(s *discordgo.Session, i *discordgo.InteractionCreate){
+		swipePrevious:   onSwipe,
+		swipeNext:       onSwipe,
+		swipeRegenerate: onSwipe,
+		replyContinue:   onContinueButton,
 	}
 	commandsHandlers = map[string]func(s *discordgo.Session, i *discordgo.InteractionCreate){
+		"continue": onContinueCommand,
+		"reset":    onResetCommand,
+		"summary":  onSummaryCommand,
+		"recall":   onRecallCommand,
 		"generate": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
 			err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
 				Type: discordgo.InteractionResponseModal,
@@ -101,6 +134,11 @@ func OnInteraction(s *discordgo.Session, i *discordgo.InteractionCreate) {
 			h(s, i)
 		}
 
+	case discordgo.InteractionMessageComponent:
+		if h, ok := componentHandlers[i.MessageComponentData().CustomID]; ok {
+			h(s, i)
+		}
+
 	case discordgo.InteractionModalSubmit:
 		err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
 			Type: discordgo.InteractionResponseChannelMessageWithSource,
@@ -130,7 +168,7 @@ func OnInteraction(s *discordgo.Session, i *discordgo.InteractionCreate) {
 			panic(err)
 		}
 
-		GenerateFromModalAndAttachMessage(s, msg, &data)
+		GenerateFromModalAndAttachMessage(s, i.Interaction, msg, &data)
 	}
 }
 
@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/M-Ro/aurora-ai/internal/gradio"
 	"github.com/M-Ro/aurora-ai/internal/stablediffusion"
 	"github.com/bwmarrin/discordgo"
 	"github.com/google/uuid"
@@ -120,39 +122,65 @@ func asValidSampler(sampler string) (string, error) {
 	return "", errors.New("Invalid sampler")
 }
 
+// GenerateFromModalAndAttachMessage generates images from the modal's parameters and
+// attaches them to msg. The interaction's response is kept updated with our place
+// in the queue.
 func GenerateFromModalAndAttachMessage(
 	s *discordgo.Session,
+	interaction *discordgo.Interaction,
 	msg *discordgo.Message,
 	data *discordgo.ModalSubmitInteractionData,
 ) {
 	discordParams := newDiscordModalParams(data)
 	sdParams := ParameterSetFromDiscordParams(discordParams)
 
-	err := stablediffusion.Run(
-		&sdParams,
-		func(images []bytes.Reader, err error) {
-			if err != nil {
-				setErrorMessage(s, msg, err)
-				return
-			}
-
-			embeds, files, err := getDiscordAttachmentsFromSdImages(images)
-			if err != nil {
-				setErrorMessage(s, msg, err)
-				return
-			}
-
-			messageEdit := discordgo.NewMessageEdit(msg.ChannelID, msg.ID)
-			messageEdit.Content = &msg.Content
-			messageEdit.Embeds = embeds
-			messageEdit.Files = files
-			_, err = s.ChannelMessageEditComplex(messageEdit)
-
-			if err != nil {
-				logrus.Error("Failed editing message with attachments")
-			}
-		},
-	)
+	jobCtx, cancel := newJobContext("stable_diffusion")
+	defer cancel()
+
+	lastUpdate := time.Time{}
+	err := withRetries(jobCtx, func() error {
+		return stablediffusion.Run(
+			jobCtx,
+			msg.ChannelID,
+			&sdParams,
+			func(est gradio.Estimation) {
+				if time.Since(lastUpdate) < queueStatusInterval {
+					return
+				}
+				lastUpdate = time.Now()
+
+				status := queueStatusMessage(est)
+				_, err := s.InteractionResponseEdit(interaction, &discordgo.WebhookEdit{
+					Content: &status,
+				})
+				if err != nil {
+					logrus.Error("Failed updating queue status: ", err)
+				}
+			},
+			func(images []bytes.Reader, err error) {
+				if err != nil {
+					setErrorMessage(s, msg, err)
+					return
+				}
+
+				embeds, files, err := getDiscordAttachmentsFromSdImages(images)
+				if err != nil {
+					setErrorMessage(s, msg, err)
+					return
+				}
+
+				messageEdit := discordgo.NewMessageEdit(msg.ChannelID, msg.ID)
+				messageEdit.Content = &msg.Content
+				messageEdit.Embeds = embeds
+				messageEdit.Files = files
+				_, err = s.ChannelMessageEditComplex(messageEdit)
+
+				if err != nil {
+					logrus.Error("Failed editing message with attachments")
+				}
+			},
+		)
+	})
 
 	if err != nil {
 		setErrorMessage(s, msg, err)
@@ -192,9 +220,11 @@ func getDiscordAttachmentsFromSdImages(
 }
 
 func setErrorMessage(s *discordgo.Session, msg *discordgo.Message, err error) {
-	content := fmt.Sprintf("%s\n%s", msg.Content, err)
+	logrus.Error("Image generation failed: ", err)
+
+	content := fmt.Sprintf("%s\n%s", msg.Content, errorMessage(err))
 	_, editErr := s.ChannelMessageEdit(msg.ChannelID, msg.ID, content)
 	if editErr != nil {
-		logrus.Error("Ironic error outputting error message for error: %s", err)
+		logrus.Errorf("Ironic error outputting error message for error: %s", err)
 	}
 }
@@ -0,0 +1,174 @@
+package discord
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/M-Ro/aurora-ai/internal/textgen/context"
+	"github.com/bwmarrin/discordgo"
+	"github.com/sirupsen/logrus"
+)
+
+// Custom ids of the buttons under each reply.
+const (
+	swipePrevious   = "swipe_previous"
+	swipeNext       = "swipe_next"
+	swipeRegenerate = "swipe_regenerate"
+	swipeCounter    = "swipe_counter"
+	replyContinue   = "reply_continue"
+)
+
+var (
+	// busyReplies holds the ids of the replies being regenerated or continued, so only
+	// one job works on a reply at once
+	busyReplies   = map[string]bool{}
+	busyRepliesMu sync.Mutex
+)
+
+// claimReply marks the reply as being worked on, returning false if it already is.
+// The returned func releases it.
+func claimReply(messageID string) (bool, func()) {
+	busyRepliesMu.Lock()
+	defer busyRepliesMu.Unlock()
+
+	if busyReplies[messageID] {
+		return false, func() {}
+	}
+	busyReplies[messageID] = true
+
+	return true, func() {
+		busyRepliesMu.Lock()
+		delete(busyReplies, messageID)
+		busyRepliesMu.Unlock()
+	}
+}
+
+// replyButtons returns the buttons under a reply, to pick between its alternatives with
+// or have it continued.
+func replyButtons(ctxMsg *context.ContextMessage) []discordgo.MessageComponent {
+	return []discordgo.MessageComponent{
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.Button{
+					CustomID: swipePrevious,
+					Label:    "Previous",
+					Style:    discordgo.SecondaryButton,
+					Disabled: ctxMsg.Selected <= 0,
+				},
+				discordgo.Button{
+					CustomID: swipeCounter,
+					Label:    fmt.Sprintf("%d/%d", ctxMsg.Selected+1, ctxMsg.AlternativeCount()),
+					Style:    discordgo.SecondaryButton,
+					Disabled: true,
+				},
+				discordgo.Button{
+					CustomID: swipeNext,
+					Label:    "Next",
+					Style:    discordgo.SecondaryButton,
+					Disabled: ctxMsg.Selected >= ctxMsg.AlternativeCount()-1,
+				},
+				discordgo.Button{
+					CustomID: swipeRegenerate,
+					Label:    "Regenerate",
+					Style:    discordgo.PrimaryButton,
+				},
+				discordgo.Button{
+					CustomID: replyContinue,
+					Label:    "Continue",
+					Style:    discordgo.SecondaryButton,
+				},
+			},
+		},
+	}
+}
+
+// onSwipe handles the buttons under a reply.
+func onSwipe(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	chatCtx := context.GetContext(i.ChannelID)
+
+	switch i.MessageComponentData().CustomID {
+	case swipePrevious:
+		swipe(s, i, chatCtx, -1)
+	case swipeNext:
+		swipe(s, i, chatCtx, 1)
+	case swipeRegenerate:
+		reworkButton(s, i, regenerating)
+	}
+}
+
+// swipe selects the alternative step away from the one shown of the reply.
+func swipe(s *discordgo.Session, i *discordgo.InteractionCreate, chatCtx *context.ChatContext, step int) {
+	chatCtx.Lock()
+	index := chatCtx.Find(i.Message.ID)
+	if index < 0 {
+		chatCtx.Unlock()
+		respondEphemeral(s, i, "That reply is no longer part of the conversation.")
+		return
+	}
+
+	ctxMsg := &chatCtx.Messages[index]
+	if !ctxMsg.SelectAlternative(ctxMsg.Selected + step) {
+		chatCtx.Unlock()
+		respondEphemeral(s, i, "There is no other reply that way.")
+		return
+	}
+
+	shown := *ctxMsg
+	text := mentionSpeakers(shown.Message, chatCtx)
+	chatCtx.EnforceSize()
+	chatCtx.Unlock()
+
+	remember(i.ChannelID, shown)
+
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredMessageUpdate,
+	})
+	if err != nil {
+		logrus.Error("fek", err)
+		return
+	}
+
+	err = showReply(s, i.ChannelID, &shown, text)
+	if err != nil {
+		logrus.Error("fek", err)
+	}
+}
+
+// regenerating generates another reply in place of one, from the conversation as it
+// was up to it.
+var regenerating = replyRework{
+	name: "Regenerating",
+	prompt: func(chatCtx *context.ChatContext, index int) *context.ChatContext {
+		return chatCtx.Before(index)
+	},
+	text: func(reply context.ContextMessage, output string) string {
+		return output
+	},
+	apply: (*context.ContextMessage).AddAlternative,
+}
+
+// respondEphemeral answers an interaction with a message only its user sees.
+func respondEphemeral(s *discordgo.Session, i *discordgo.InteractionCreate, content string) {
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: content,
+			Flags:   discordgo.MessageFlagsEphemeral,
+		},
+	})
+	if err != nil {
+		logrus.Error("fek", err)
+	}
+}
+
+// respondFollowup tells the user of an interaction already responded to something only
+// they see.
+func respondFollowup(s *discordgo.Session, i *discordgo.InteractionCreate, content string) {
+	_, err := s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+		Content: content,
+		Flags:   discordgo.MessageFlagsEphemeral,
+	})
+	if err != nil {
+		logrus.Error("fek", err)
+	}
+}
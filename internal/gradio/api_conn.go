@@ -1,11 +1,15 @@
 package gradio
 
 import (
-	"crypto/tls"
+	"context"
 	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
 	"github.com/gorilla/websocket"
 	"github.com/sirupsen/logrus"
-	"io"
 )
 
 var (
@@ -24,6 +28,8 @@ const (
 type APIConnection struct {
 	Status Status
 	Ws     *websocket.Conn
+
+	closeOnce sync.Once
 }
 
 func NewAPIConnection() *APIConnection {
@@ -35,18 +41,32 @@ func NewAPIConnection() *APIConnection {
 	return &conn
 }
 
-func (conn *APIConnection) Connect(host string) error {
+func (conn *APIConnection) Connect(ctx context.Context, backend *Backend) error {
 	conn.Status = Connecting
 
-	dialer := *websocket.DefaultDialer
-	dialer.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
-
-	c, resp, err := dialer.Dial(
-		"ws://"+host+"/queue/join",
-		nil,
+	c, resp, err := backend.Dialer().DialContext(
+		ctx,
+		backend.WebsocketURL("/queue/join"),
+		backend.Header(),
 	)
 
+	// Our session cookie is invalidated when the app restarts, so log in again
+	if resp != nil && resp.StatusCode == http.StatusUnauthorized && backend.Auth.Gradio.isSet() {
+		if backend.Login() == nil {
+			c, resp, err = backend.Dialer().DialContext(
+				ctx,
+				backend.WebsocketURL("/queue/join"),
+				backend.Header(),
+			)
+		}
+	}
+
 	if err != nil {
+		if ctx.Err() != nil {
+			conn.Status = Disconnected
+			return ctx.Err()
+		}
+
 		logrus.Error(err)
 
 		if resp != nil {
@@ -70,6 +90,27 @@ func (conn *APIConnection) Connect(host string) error {
 
 func (conn *APIConnection) Disconnect() {
 	if conn.Status == Connected {
-		conn.Ws.Close()
+		conn.closeOnce.Do(func() {
+			conn.Ws.Close()
+		})
 	}
 }
+
+// Close tells the server we are leaving before disconnecting, which lets it drop
+// the job we were waiting on.
+func (conn *APIConnection) Close() {
+	if conn.Status != Connected {
+		return
+	}
+
+	err := conn.Ws.WriteControl(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+		time.Now().Add(time.Second),
+	)
+	if err != nil {
+		logrus.Debug("ws close: ", err)
+	}
+
+	conn.Disconnect()
+}
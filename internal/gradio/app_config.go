@@ -0,0 +1,458 @@
+package gradio
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	ErrFetchConfig     = errors.New("Failed to fetch app config")
+	ErrParseConfig     = errors.New("Failed parsing app config")
+	ErrUnknownEndpoint = errors.New("Endpoint is not configured")
+)
+
+// AppConfig is the subset of a Gradio app's /config we need to locate functions.
+type AppConfig struct {
+	Version      string       `json:"version"`
+	ProtocolName string       `json:"protocol"`
+	Dependencies []Dependency `json:"dependencies"`
+	Components   []Component  `json:"components"`
+
+	// hash identifies the config, to tell when the app has changed
+	hash string
+}
+
+// Protocol is the transport the app's queue is reached through.
+type Protocol int
+
+const (
+	ProtocolWebsocket Protocol = iota
+	// ProtocolSSE is Gradio 4.0's, where the job is joined by opening its event stream
+	// and its data posted once the app asks for it
+	ProtocolSSE
+	// ProtocolSSEv1 posts the job to /queue/join and reads the session's /queue/data
+	ProtocolSSEv1
+	// ProtocolSSEv2 is v1 sending each process_generating output after the first as a
+	// diff against the one before, as sse_v2.1 & sse_v3 do too
+	ProtocolSSEv2
+)
+
+// Protocol returns the queue transport spoken by the app. Apps which don't report
+// it are assumed to use websockets before Gradio 4, and SSE v1 from then on.
+func (cfg *AppConfig) Protocol() (Protocol, error) {
+	switch cfg.ProtocolName {
+	case "ws":
+		return ProtocolWebsocket, nil
+	case "sse":
+		return ProtocolSSE, nil
+	case "sse_v1":
+		return ProtocolSSEv1, nil
+	case "sse_v2", "sse_v2.1", "sse_v3":
+		return ProtocolSSEv2, nil
+	case "":
+	default:
+		return ProtocolWebsocket, &UnsupportedProtocolError{Name: cfg.ProtocolName}
+	}
+
+	major, err := strconv.Atoi(strings.SplitN(cfg.Version, ".", 2)[0])
+	if err == nil && major >= 4 {
+		return ProtocolSSEv1, nil
+	}
+
+	return ProtocolWebsocket, nil
+}
+
+// Dependency is a function registered with the app. Its position in the
+// dependency list is the fn_index used by the queue.
+type Dependency struct {
+	ApiName apiName   `json:"api_name"`
+	Targets targetIds `json:"targets"`
+	Inputs  []int     `json:"inputs"`
+	Outputs []int     `json:"outputs"`
+}
+
+type Component struct {
+	Id    int                    `json:"id"`
+	Type  string                 `json:"type"`
+	Props map[string]interface{} `json:"props"`
+}
+
+// apiName is either a string or false/null when the function isn't exposed.
+type apiName string
+
+func (n *apiName) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		*n = ""
+		return nil
+	}
+
+	*n = apiName(s)
+	return nil
+}
+
+// targetIds holds the ids of the components triggering a dependency.
+// Older apps send a list of ids, newer ones send [id, event] pairs.
+type targetIds []int
+
+func (t *targetIds) UnmarshalJSON(data []byte) error {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	ids := targetIds{}
+	for _, r := range raw {
+		var id int
+		if err := json.Unmarshal(r, &id); err == nil {
+			ids = append(ids, id)
+			continue
+		}
+
+		var pair []interface{}
+		if err := json.Unmarshal(r, &pair); err == nil && len(pair) > 0 {
+			if f, ok := pair[0].(float64); ok {
+				ids = append(ids, int(f))
+			}
+		}
+	}
+
+	*t = ids
+	return nil
+}
+
+// ElemId returns the html element id assigned to the component, if any.
+func (c *Component) ElemId() string {
+	s, _ := c.Props["elem_id"].(string)
+	return s
+}
+
+// Label returns the text a user would see on the component, if any.
+func (c *Component) Label() string {
+	for _, key := range []string{"label", "value"} {
+		if s, ok := c.Props[key].(string); ok && s != "" {
+			return s
+		}
+	}
+
+	return ""
+}
+
+// Endpoint identifies a function either by its api_name, or by the elem_id or label
+// of the component which triggers it for functions the app doesn't name.
+type Endpoint struct {
+	ApiName string `mapstructure:"api_name"`
+	ElemId  string `mapstructure:"elem_id"`
+	Label   string `mapstructure:"label"`
+}
+
+func (e Endpoint) String() string {
+	if e.ApiName != "" {
+		return fmt.Sprintf("api_name %q", e.ApiName)
+	}
+
+	if e.ElemId != "" {
+		return fmt.Sprintf("elem_id %q", e.ElemId)
+	}
+
+	return fmt.Sprintf("label %q", e.Label)
+}
+
+// matches returns whether the endpoint refers to a function triggered by c.
+func (e Endpoint) matches(c *Component) bool {
+	if e.ElemId != "" {
+		return c.ElemId() == e.ElemId
+	}
+
+	return e.Label != "" && c.Label() == e.Label
+}
+
+// EndpointSet maps the names the bot uses for functions to the app's endpoints.
+type EndpointSet map[string]Endpoint
+
+// MissingEndpointsError is returned when the app no longer exposes an expected endpoint.
+type MissingEndpointsError struct {
+	Host      string
+	Missing   []string
+	Available []string
+}
+
+func (e *MissingEndpointsError) Error() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "Gradio app at %s is missing expected endpoints:", e.Host)
+	for _, m := range e.Missing {
+		fmt.Fprintf(&b, "\n- %s", m)
+	}
+	for _, a := range e.Available {
+		fmt.Fprintf(&b, "\n+ %s", a)
+	}
+
+	return b.String()
+}
+
+// FetchAppConfig downloads and parses the app config from the backend.
+func FetchAppConfig(ctx context.Context, backend *Backend) (*AppConfig, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, backend.URL("/config"), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := backend.HTTPClient().Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		logrus.Error(err)
+		return nil, ErrFetchConfig
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		logrus.Error("GET /config: ", res.Status)
+		return nil, ErrFetchConfig
+	}
+
+	cfg := AppConfig{}
+	err = json.NewDecoder(res.Body).Decode(&cfg)
+	if err != nil {
+		logrus.Error(err)
+		return nil, ErrParseConfig
+	}
+
+	// Only what we use of the config is hashed, so changes elsewhere don't count
+	data, err := json.Marshal(&cfg)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	cfg.hash = hex.EncodeToString(sum[:])
+
+	return &cfg, nil
+}
+
+// FnIndex returns the fn_index of the dependency matching the endpoint.
+func (cfg *AppConfig) FnIndex(e Endpoint) (uint32, bool) {
+	if e.ApiName != "" {
+		for i, dep := range cfg.Dependencies {
+			if string(dep.ApiName) == e.ApiName {
+				return uint32(i), true
+			}
+		}
+
+		return 0, false
+	}
+
+	components := make(map[int]*Component, len(cfg.Components))
+	for i := range cfg.Components {
+		components[cfg.Components[i].Id] = &cfg.Components[i]
+	}
+
+	for i, dep := range cfg.Dependencies {
+		for _, id := range dep.Targets {
+			c, ok := components[id]
+			if ok && e.matches(c) {
+				return uint32(i), true
+			}
+		}
+	}
+
+	return 0, false
+}
+
+// Resolve maps every endpoint in the set to its fn index, failing with a
+// MissingEndpointsError listing what the app does expose if any can't be found.
+func (cfg *AppConfig) Resolve(host string, endpoints EndpointSet) (map[string]uint32, error) {
+	indices := make(map[string]uint32, len(endpoints))
+	missing := []string{}
+
+	for name, e := range endpoints {
+		idx, ok := cfg.FnIndex(e)
+		if !ok {
+			missing = append(missing, fmt.Sprintf("%s (%s)", name, e))
+			continue
+		}
+
+		indices[name] = idx
+	}
+
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, &MissingEndpointsError{
+			Host:      host,
+			Missing:   missing,
+			Available: cfg.available(),
+		}
+	}
+
+	return indices, nil
+}
+
+func (cfg *AppConfig) available() []string {
+	available := []string{}
+	for i, dep := range cfg.Dependencies {
+		if dep.ApiName != "" {
+			available = append(available, fmt.Sprintf("%d: api_name %q", i, dep.ApiName))
+		}
+	}
+
+	return available
+}
+
+// apps caches what we know about the app at each host so /config is only fetched once,
+// or again when the app changes.
+var (
+	appsMu sync.Mutex
+	apps   = map[string]*appState{}
+)
+
+type appState struct {
+	protocol    Protocol
+	protocolErr error
+	hash        string
+	// endpoints are those resolved on the app so far, resolved again when it changes
+	endpoints EndpointSet
+	indices   map[string]uint32
+}
+
+// appStateFor returns the cached state for host, fetching its config if there is none.
+func appStateFor(ctx context.Context, backend *Backend) (*appState, error) {
+	appsMu.Lock()
+	app, ok := apps[backend.Host]
+	appsMu.Unlock()
+
+	if ok {
+		return app, nil
+	}
+
+	cfg, err := FetchAppConfig(ctx, backend)
+	if err != nil {
+		return nil, err
+	}
+
+	return storeAppConfig(backend.Host, cfg, nil)
+}
+
+// ResolveEndpoints fetches the app config from the backend and caches the fn index of
+// every endpoint in the set.
+func ResolveEndpoints(ctx context.Context, backend *Backend, endpoints EndpointSet) error {
+	cfg, err := FetchAppConfig(ctx, backend)
+	if err != nil {
+		return err
+	}
+
+	_, err = storeAppConfig(backend.Host, cfg, endpoints)
+	return err
+}
+
+// refreshAppConfig resolves the endpoints of the app at host again from its config if
+// the config changed since they were resolved, or always if force is set. Nothing is
+// done for apps not used yet.
+func refreshAppConfig(host string, cfg *AppConfig, force bool) error {
+	appsMu.Lock()
+	app, ok := apps[host]
+	appsMu.Unlock()
+
+	if !ok || (!force && app.hash == cfg.hash) {
+		return nil
+	}
+
+	if app.hash != cfg.hash {
+		logrus.Infof("App config of %s changed, resolving its endpoints again", host)
+	}
+
+	_, err := storeAppConfig(host, cfg, nil)
+	return err
+}
+
+// storeAppConfig caches what cfg says of the app at host, resolving the endpoints along
+// with those resolved on it before. Should any be missing, the app is dropped from the
+// cache so it's fetched afresh on next use.
+func storeAppConfig(host string, cfg *AppConfig, endpoints EndpointSet) (*appState, error) {
+	appsMu.Lock()
+	defer appsMu.Unlock()
+
+	prev, ok := apps[host]
+
+	all := EndpointSet{}
+	if ok {
+		for name, e := range prev.endpoints {
+			all[name] = e
+		}
+	}
+	for name, e := range endpoints {
+		all[name] = e
+	}
+
+	indices, err := cfg.Resolve(host, all)
+	if err != nil {
+		delete(apps, host)
+		return nil, err
+	}
+
+	protocol, protocolErr := cfg.Protocol()
+	if protocolErr != nil {
+		logrus.Error(protocolErr)
+	}
+
+	app := &appState{
+		protocol:    protocol,
+		protocolErr: protocolErr,
+		hash:        cfg.hash,
+		endpoints:   all,
+		indices:     indices,
+	}
+
+	for name, idx := range indices {
+		if ok {
+			if prevIdx, found := prev.indices[name]; found {
+				if prevIdx != idx {
+					logrus.Warnf("fn_index for %s on %s moved from %d to %d", name, host, prevIdx, idx)
+				}
+
+				continue
+			}
+		}
+
+		logrus.Infof("Resolved %s on %s to fn_index %d", name, host, idx)
+	}
+
+	apps[host] = app
+
+	return app, nil
+}
+
+func cachedFnIndex(host string, name string) (uint32, bool) {
+	appsMu.Lock()
+	defer appsMu.Unlock()
+
+	app, ok := apps[host]
+	if !ok {
+		return 0, false
+	}
+
+	idx, ok := app.indices[name]
+	return idx, ok
+}
+
+// appProtocol returns the queue transport used by the app on the backend.
+func appProtocol(ctx context.Context, backend *Backend) (Protocol, error) {
+	app, err := appStateFor(ctx, backend)
+	if err != nil {
+		return ProtocolWebsocket, err
+	}
+
+	return app.protocol, app.protocolErr
+}
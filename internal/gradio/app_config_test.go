@@ -0,0 +1,122 @@
+package gradio
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/M-Ro/aurora-ai/internal/gradio/fake"
+)
+
+// startFakeApp serves a fake app with the functions, returning a backend for it.
+func startFakeApp(t *testing.T, functions []fake.Function) (*fake.Server, *Backend) {
+	server := fake.NewServer("4.36.0", functions)
+	httpServer := httptest.NewServer(server.Handler())
+	t.Cleanup(httpServer.Close)
+
+	return server, newTestBackend(t, httpServer.Listener.Addr().String())
+}
+
+func TestResolveEndpoints(t *testing.T) {
+	_, backend := startFakeApp(t, []fake.Function{
+		{ApiName: "parameters"},
+		{ElemId: "txt2img_generate"},
+		{Label: "Generate"},
+	})
+
+	err := ResolveEndpoints(context.Background(), backend, EndpointSet{
+		"parameters": {ApiName: "parameters"},
+		"txt2img":    {ElemId: "txt2img_generate"},
+		"generate":   {Label: "Generate"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]uint32{"parameters": 0, "txt2img": 1, "generate": 2} {
+		idx, ok := cachedFnIndex(backend.Host, name)
+		if !ok || idx != want {
+			t.Errorf("%s resolved to %d, want %d", name, idx, want)
+		}
+	}
+
+	err = ResolveEndpoints(context.Background(), backend, EndpointSet{
+		"missing": {ApiName: "img2img"},
+		"label":   {Label: "Stop"},
+	})
+
+	var missing *MissingEndpointsError
+	if !errors.As(err, &missing) {
+		t.Fatalf("expected missing endpoints, got %v", err)
+	}
+
+	if len(missing.Missing) != 2 || !strings.Contains(missing.Missing[0], `label "Stop"`) ||
+		len(missing.Available) != 1 || !strings.Contains(missing.Available[0], `api_name "parameters"`) {
+		t.Errorf("unexpected error %q", err)
+	}
+}
+
+func TestResolveEndpointsChanged(t *testing.T) {
+	server, backend := startFakeApp(t, []fake.Function{
+		{ApiName: "parameters"},
+		{ApiName: "generate"},
+	})
+
+	pool := NewPool(RoundRobin)
+	pool.Add(backend, 1)
+
+	err := pool.ResolveEndpoints(context.Background(), EndpointSet{"generate": {ApiName: "generate"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The app is upgraded under us, moving the function
+	server.Functions = []fake.Function{{ApiName: "stop"}, {ApiName: "parameters"}, {ApiName: "generate"}}
+	pool.CheckHealth()
+
+	if idx, _ := cachedFnIndex(backend.Host, "generate"); idx != 2 {
+		t.Errorf("generate still resolved to %d after the app changed", idx)
+	}
+
+	// And then no longer has it at all
+	server.Functions = []fake.Function{{ApiName: "parameters"}}
+	pool.CheckHealth()
+
+	if _, ok := cachedFnIndex(backend.Host, "generate"); ok || backend.Healthy() {
+		t.Errorf("expected the backend missing generate to be dropped & marked down")
+	}
+}
+
+func TestResolveEndpointsHungApp(t *testing.T) {
+	hung := make(chan struct{})
+	hungServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-hung
+	}))
+	t.Cleanup(hungServer.Close)
+	t.Cleanup(func() { close(hung) })
+
+	_, backend := startFakeApp(t, []fake.Function{{ApiName: "generate"}})
+	hungBackend := newTestBackend(t, hungServer.Listener.Addr().String())
+
+	go ResolveEndpoints(context.Background(), hungBackend, EndpointSet{"generate": {ApiName: "generate"}})
+	time.Sleep(50 * time.Millisecond)
+
+	// Other apps are resolved while the hung one is fetched
+	done := make(chan error)
+	go func() {
+		done <- ResolveEndpoints(context.Background(), backend, EndpointSet{"generate": {ApiName: "generate"}})
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("resolving was blocked by another app")
+	}
+}
@@ -0,0 +1,147 @@
+package gradio
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	ErrLoginFailed = errors.New("Failed to log in to Gradio app")
+)
+
+// loginTimeout bounds a login, so an unreachable backend can't hold up the others.
+const loginTimeout = 10 * time.Second
+
+// Credentials is a username & password pair.
+type Credentials struct {
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+}
+
+func (c *Credentials) isSet() bool {
+	return c.Username != "" || c.Password != ""
+}
+
+// AuthConfig configures how we authenticate to a backend.
+// Gradio credentials are those the app was launched with through --gradio-auth, basic
+// credentials & headers are sent with every request, e.g for a reverse proxy.
+type AuthConfig struct {
+	Gradio  Credentials       `mapstructure:"gradio"`
+	Basic   Credentials       `mapstructure:"basic"`
+	Headers map[string]string `mapstructure:"headers"`
+}
+
+// apply sets the basic auth & custom headers on a request header.
+func (a *AuthConfig) apply(header http.Header) {
+	for k, v := range a.Headers {
+		header.Set(k, v)
+	}
+
+	if a.Basic.isSet() {
+		r := http.Request{Header: header}
+		r.SetBasicAuth(a.Basic.Username, a.Basic.Password)
+	}
+}
+
+// Header returns the headers to send when dialing the backend's websocket.
+func (b *Backend) Header() http.Header {
+	header := http.Header{}
+	b.Auth.apply(header)
+
+	return header
+}
+
+// Login exchanges the backend's Gradio credentials for a session cookie, which is kept
+// in the backend's cookie jar for every following request and websocket.
+func (b *Backend) Login() error {
+	if !b.Auth.Gradio.isSet() {
+		return nil
+	}
+
+	form := url.Values{
+		"username": {b.Auth.Gradio.Username},
+		"password": {b.Auth.Gradio.Password},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), loginTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.URL("/login"), strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	// Go through the base transport directly so a rejected login isn't retried
+	res, err := b.transport.RoundTrip(b.authorize(req))
+	if err != nil {
+		logrus.Error(err)
+		return ErrLoginFailed
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		logRejectedResponse(res)
+		return ErrLoginFailed
+	}
+
+	b.jar.SetCookies(req.URL, res.Cookies())
+
+	logrus.Infof("Logged in to %s as %s", b.Host, b.Auth.Gradio.Username)
+
+	return nil
+}
+
+// authorize returns a copy of req carrying the backend's auth headers.
+func (b *Backend) authorize(req *http.Request) *http.Request {
+	r := req.Clone(req.Context())
+	b.Auth.apply(r.Header)
+
+	return r
+}
+
+// authTransport adds the backend's credentials to every request, logging in again
+// if the app rejects our session cookie, such as after the app was restarted.
+type authTransport struct {
+	backend *Backend
+	base    http.RoundTripper
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.base.RoundTrip(t.backend.authorize(req))
+	if err != nil || res.StatusCode != http.StatusUnauthorized {
+		return res, err
+	}
+
+	// Only retry when the request can be replayed
+	if !t.backend.Auth.Gradio.isSet() || (req.Body != nil && req.GetBody == nil) {
+		return res, nil
+	}
+
+	if t.backend.Login() != nil {
+		return res, nil
+	}
+	res.Body.Close()
+
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		retry.Body, err = req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// The client only adds cookies before the first attempt, so add the new ones
+	retry.Header.Del("Cookie")
+	for _, cookie := range t.backend.jar.Cookies(req.URL) {
+		retry.AddCookie(cookie)
+	}
+
+	return t.base.RoundTrip(t.backend.authorize(retry))
+}
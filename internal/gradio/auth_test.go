@@ -0,0 +1,114 @@
+package gradio
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// authApp is an app launched with --gradio-auth behind a proxy requiring basic auth &
+// an api key header.
+type authApp struct {
+	mu     sync.Mutex
+	token  string
+	logins int
+}
+
+func (a *authApp) handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		if !ok || user != "proxy" || password != "hunter2" || r.Header.Get("X-Api-Key") != "key" {
+			http.Error(w, "proxy says no", http.StatusForbidden)
+			return
+		}
+
+		a.mu.Lock()
+		defer a.mu.Unlock()
+
+		if r.URL.Path == "/login" {
+			if r.FormValue("username") != "aurora" || r.FormValue("password") != "secret" {
+				http.Error(w, "Incorrect credentials.", http.StatusBadRequest)
+				return
+			}
+
+			a.logins++
+			http.SetCookie(w, &http.Cookie{Name: "access-token", Value: a.token, Path: "/"})
+			return
+		}
+
+		cookie, err := r.Cookie("access-token")
+		if err != nil || cookie.Value != a.token {
+			http.Error(w, "Not authenticated", http.StatusUnauthorized)
+			return
+		}
+
+		w.Write([]byte(`{"version": "4.36.0", "protocol": "sse_v3", "dependencies": []}`))
+	})
+}
+
+func TestLogin(t *testing.T) {
+	app := &authApp{token: "first"}
+	server := httptest.NewServer(app.handler())
+	defer server.Close()
+
+	auth := AuthConfig{
+		Gradio:  Credentials{Username: "aurora", Password: "secret"},
+		Basic:   Credentials{Username: "proxy", Password: "hunter2"},
+		Headers: map[string]string{"X-Api-Key": "key"},
+	}
+
+	backend, err := NewBackend(server.Listener.Addr().String(), "http", TLSConfig{}, auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The session cookie is reused rather than logging in for every request
+	for i := 0; i < 2; i++ {
+		_, err = FetchAppConfig(context.Background(), backend)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if app.logins != 1 {
+		t.Errorf("logged in %d times, want 1", app.logins)
+	}
+
+	// A restarted app forgets the session, so we log in again
+	app.mu.Lock()
+	app.token = "second"
+	app.mu.Unlock()
+
+	_, err = FetchAppConfig(context.Background(), backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if app.logins != 2 {
+		t.Errorf("logged in %d times, want 2", app.logins)
+	}
+
+	header := backend.Header()
+	if header.Get("X-Api-Key") != "key" || header.Get("Authorization") == "" {
+		t.Errorf("websocket header missing credentials: %v", header)
+	}
+}
+
+func TestLoginRejected(t *testing.T) {
+	app := &authApp{token: "first"}
+	server := httptest.NewServer(app.handler())
+	defer server.Close()
+
+	auth := AuthConfig{
+		Gradio:  Credentials{Username: "aurora", Password: "wrong"},
+		Basic:   Credentials{Username: "proxy", Password: "hunter2"},
+		Headers: map[string]string{"X-Api-Key": "key"},
+	}
+
+	_, err := NewBackend(server.Listener.Addr().String(), "http", TLSConfig{}, auth)
+	if err != ErrLoginFailed {
+		t.Errorf("expected the login to fail, got %v", err)
+	}
+}
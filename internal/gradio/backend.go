@@ -0,0 +1,233 @@
+package gradio
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	ErrInvalidScheme = errors.New("Backend scheme must be http or https")
+	ErrLoadCA        = errors.New("Failed to load CA bundle")
+	ErrLoadCert      = errors.New("Failed to load client certificate")
+)
+
+// TLSConfig configures how we verify and authenticate to a backend served over https.
+type TLSConfig struct {
+	CAFile             string `mapstructure:"ca_file"`
+	CertFile           string `mapstructure:"cert_file"`
+	KeyFile            string `mapstructure:"key_file"`
+	InsecureSkipVerify bool   `mapstructure:"insecure_skip_verify"`
+}
+
+// Backend holds the connection settings of a single Gradio app, shared by the queue
+// connection and file downloads.
+type Backend struct {
+	Host   string     `mapstructure:"host"`
+	Scheme string     `mapstructure:"scheme"`
+	TLS    TLSConfig  `mapstructure:"tls"`
+	Auth   AuthConfig `mapstructure:"auth"`
+
+	tlsConfig  *tls.Config
+	jar        http.CookieJar
+	transport  *http.Transport
+	httpClient *http.Client
+
+	stats backendStats
+}
+
+// backendStats is what we know of a backend's load & health, used to pick between
+// the backends of a pool.
+type backendStats struct {
+	mu         sync.Mutex
+	down       bool
+	queueSize  int
+	observedAt time.Time
+	inflight   int
+}
+
+// queueSizeTTL is how long a queue size seen in an estimation is trusted for.
+const queueSizeTTL = 30 * time.Second
+
+// NewBackend builds a backend from its settings, loading any certificates it references
+// and logging in if the app requires it.
+func NewBackend(host string, scheme string, tlsCfg TLSConfig, auth AuthConfig) (*Backend, error) {
+	b := Backend{
+		Host:   host,
+		Scheme: scheme,
+		TLS:    tlsCfg,
+		Auth:   auth,
+	}
+
+	err := b.init()
+	if err != nil {
+		return nil, err
+	}
+
+	err = b.Login()
+	if err != nil {
+		return nil, err
+	}
+
+	return &b, nil
+}
+
+func (b *Backend) init() error {
+	if b.Scheme == "" {
+		b.Scheme = "http"
+	}
+
+	if b.Scheme != "http" && b.Scheme != "https" {
+		return ErrInvalidScheme
+	}
+
+	tlsConfig, err := b.TLS.load()
+	if err != nil {
+		return err
+	}
+
+	if tlsConfig.InsecureSkipVerify {
+		logrus.Warnf("TLS verification is disabled for %s", b.Host)
+	}
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return err
+	}
+
+	b.tlsConfig = tlsConfig
+	b.jar = jar
+	b.transport = &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: tlsConfig,
+	}
+	b.httpClient = &http.Client{
+		Transport: &authTransport{backend: b, base: b.transport},
+		Jar:       jar,
+	}
+
+	return nil
+}
+
+func (t *TLSConfig) load() (*tls.Config, error) {
+	cfg := &tls.Config{
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+
+	if t.CAFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+
+		pem, err := ioutil.ReadFile(t.CAFile)
+		if err != nil {
+			logrus.Error(err)
+			return nil, ErrLoadCA
+		}
+
+		if !pool.AppendCertsFromPEM(pem) {
+			logrus.Error("No certificates found in ", t.CAFile)
+			return nil, ErrLoadCA
+		}
+
+		cfg.RootCAs = pool
+	}
+
+	if t.CertFile != "" || t.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			logrus.Error(err)
+			return nil, ErrLoadCert
+		}
+
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg, nil
+}
+
+// URL returns the http(s) url of path on the backend.
+func (b *Backend) URL(path string) string {
+	return fmt.Sprintf("%s://%s%s", b.Scheme, b.Host, path)
+}
+
+// WebsocketURL returns the ws(s) url of path on the backend.
+func (b *Backend) WebsocketURL(path string) string {
+	scheme := "ws"
+	if b.Scheme == "https" {
+		scheme = "wss"
+	}
+
+	return fmt.Sprintf("%s://%s%s", scheme, b.Host, path)
+}
+
+// HTTPClient returns the client used for every plain http request to the backend.
+func (b *Backend) HTTPClient() *http.Client {
+	return b.httpClient
+}
+
+// Dialer returns a websocket dialer sharing the backend's tls settings & cookies.
+// Dial with Header() to send the backend's credentials.
+func (b *Backend) Dialer() *websocket.Dialer {
+	dialer := *websocket.DefaultDialer
+	dialer.TLSClientConfig = b.tlsConfig
+	dialer.Jar = b.jar
+
+	return &dialer
+}
+
+// observe records the queue size reported by the backend in an estimation.
+func (b *Backend) observe(est Estimation) {
+	b.stats.mu.Lock()
+	defer b.stats.mu.Unlock()
+
+	b.stats.queueSize = est.QueueSize
+	b.stats.observedAt = time.Now()
+}
+
+// load returns how busy the backend is, going by the jobs we have running on it and
+// the last queue size it reported.
+func (b *Backend) load() int {
+	b.stats.mu.Lock()
+	defer b.stats.mu.Unlock()
+
+	load := b.stats.inflight
+	if time.Since(b.stats.observedAt) < queueSizeTTL && b.stats.queueSize > load {
+		load = b.stats.queueSize
+	}
+
+	return load
+}
+
+// Healthy returns whether the backend answered the last time we tried to reach it.
+func (b *Backend) Healthy() bool {
+	b.stats.mu.Lock()
+	defer b.stats.mu.Unlock()
+
+	return !b.stats.down
+}
+
+func (b *Backend) setHealthy(healthy bool) {
+	b.stats.mu.Lock()
+	defer b.stats.mu.Unlock()
+
+	if b.stats.down == healthy {
+		if healthy {
+			logrus.Infof("Backend %s is back up", b.Host)
+		} else {
+			logrus.Warnf("Backend %s is down", b.Host)
+		}
+	}
+
+	b.stats.down = !healthy
+}
@@ -0,0 +1,250 @@
+package gradio
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/M-Ro/aurora-ai/api"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	ErrConnectionLost = errors.New("Queue connection closed before the job completed")
+	ErrNotCompleted   = errors.New("Job finished without a completion packet")
+)
+
+// Client runs jobs on a single Gradio app through its queue.
+type Client struct {
+	Backend   *Backend
+	Session   *Session
+	Endpoints EndpointSet
+}
+
+func NewClient(backend *Backend, session *Session, endpoints EndpointSet) *Client {
+	c := Client{
+		Backend:   backend,
+		Session:   session,
+		Endpoints: endpoints,
+	}
+
+	return &c
+}
+
+// FnIndex returns the fn index of a named endpoint, resolving the client's endpoints
+// from the app config the first time one is requested.
+func (c *Client) FnIndex(ctx context.Context, name string) (uint32, error) {
+	if idx, ok := cachedFnIndex(c.Backend.Host, name); ok {
+		return idx, nil
+	}
+
+	if _, ok := c.Endpoints[name]; !ok {
+		return 0, ErrUnknownEndpoint
+	}
+
+	err := ResolveEndpoints(ctx, c.Backend, c.Endpoints)
+	if err != nil {
+		return 0, err
+	}
+
+	idx, _ := cachedFnIndex(c.Backend.Host, name)
+	return idx, nil
+}
+
+// Event is a single packet received from the queue while a job is running.
+// Output is left raw since every app returns a differently shaped data block.
+type Event struct {
+	Message    api.GradioResponseMessage
+	Success    bool
+	Output     json.RawMessage
+	Estimation *Estimation
+	Err        error
+}
+
+// Estimation is our place in the app's queue, sent while a job waits to be processed.
+type Estimation struct {
+	// Rank is the number of jobs ahead of ours
+	Rank      int
+	QueueSize int
+	// RankEta is the estimated wait until our job starts, zero if the app can't tell yet
+	RankEta time.Duration
+}
+
+// QueueUpdateFunc is called with each estimation received while a job is queued.
+type QueueUpdateFunc func(Estimation)
+
+// Decode unmarshals the output block of the event into v.
+func (e *Event) Decode(v interface{}) error {
+	return json.Unmarshal(e.Output, v)
+}
+
+// HasOutput returns whether the server attached an output block to the event.
+func (e *Event) HasOutput() bool {
+	return len(e.Output) > 0 && string(e.Output) != "null"
+}
+
+// queuePacket is the envelope shared by every packet the queue sends us.
+type queuePacket struct {
+	Message   api.GradioResponseMessage `json:"msg"`
+	Detail    string                    `json:"message"`
+	EventId   string                    `json:"event_id"`
+	Output    json.RawMessage           `json:"output"`
+	Success   *bool                     `json:"success"`
+	Rank      *int                      `json:"rank"`
+	QueueSize *int                      `json:"queue_size"`
+	RankEta   *float64                  `json:"rank_eta"`
+}
+
+// result converts a packet received once the job has been submitted into the event for
+// the caller, which is nil if the packet is of no interest, and whether it ends the job.
+func (p *queuePacket) result() (*Event, bool) {
+	switch p.Message {
+	case api.MsgHeartbeat, api.MsgLog:
+		return nil, false
+	case api.MsgQueueFull:
+		return &Event{Err: ErrQueueFull}, true
+	case api.MsgUnexpectedError:
+		return &Event{Err: &UpstreamError{Message: p.Detail}}, true
+	case api.MsgEstimation, api.MsgProcessStarts, api.MsgProcessGenerating:
+		ev := p.event()
+		return &ev, false
+	case api.MsgProcessCompleted:
+		if p.Success != nil && !*p.Success {
+			return &Event{Err: p.upstreamError()}, true
+		}
+
+		ev := p.event()
+		return &ev, true
+	}
+
+	return &Event{Err: &UnexpectedPacketError{Message: p.Message}}, true
+}
+
+// upstreamError extracts the exception the app reported for a failed job.
+func (p *queuePacket) upstreamError() *UpstreamError {
+	output := struct {
+		Error *string `json:"error"`
+	}{}
+
+	if json.Unmarshal(p.Output, &output) == nil && output.Error != nil {
+		return &UpstreamError{Message: *output.Error}
+	}
+
+	return &UpstreamError{Message: p.Detail}
+}
+
+// event converts the packet into an event for the caller.
+func (p *queuePacket) event() Event {
+	ev := Event{
+		Message: p.Message,
+		Success: p.Success != nil && *p.Success,
+		Output:  p.Output,
+	}
+
+	if p.Message == api.MsgEstimation {
+		est := Estimation{}
+		if p.Rank != nil {
+			est.Rank = *p.Rank
+		}
+		if p.QueueSize != nil {
+			est.QueueSize = *p.QueueSize
+		}
+		if p.RankEta != nil && *p.RankEta > 0 {
+			est.RankEta = time.Duration(*p.RankEta * float64(time.Second))
+		}
+
+		ev.Estimation = &est
+	}
+
+	return ev
+}
+
+// Submit runs the function at fnIndex with data on the app's queue, using whichever
+// transport the app speaks. The handshake is handled internally, every other packet is
+// forwarded on the returned channel, which is closed once the job completes or the
+// connection fails.
+// Cancelling ctx abandons the job and closes the channel without a further event, so
+// callers should check ctx.Err() if the channel closes before completion.
+func (c *Client) Submit(ctx context.Context, fnIndex uint32, data interface{}) (<-chan Event, error) {
+	protocol, err := appProtocol(ctx, c.Backend)
+
+	var unsupported *UnsupportedProtocolError
+	if errors.As(err, &unsupported) {
+		return nil, err
+	}
+
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		logrus.Warn("Could not determine queue protocol, assuming websocket: ", err)
+	}
+
+	if protocol == ProtocolWebsocket {
+		return c.submitWebsocket(ctx, fnIndex, data)
+	}
+
+	return c.submitSSE(ctx, protocol, fnIndex, data)
+}
+
+// Call submits a job and blocks until it completes, returning the completion event.
+// onQueue may be nil.
+func (c *Client) Call(
+	ctx context.Context,
+	fnIndex uint32,
+	data interface{},
+	onQueue QueueUpdateFunc,
+) (Event, error) {
+	events, err := c.Submit(ctx, fnIndex, data)
+	if err != nil {
+		return Event{}, err
+	}
+
+	var result *Event
+	for ev := range events {
+		if ev.Err != nil {
+			return ev, ev.Err
+		}
+
+		if ev.Estimation != nil && onQueue != nil {
+			onQueue(*ev.Estimation)
+		}
+
+		if ev.Message == api.MsgProcessCompleted {
+			ev := ev
+			result = &ev
+		}
+	}
+
+	if ctx.Err() != nil {
+		return Event{}, ctx.Err()
+	}
+
+	if result == nil {
+		return Event{}, ErrNotCompleted
+	}
+
+	return *result, nil
+}
+
+// forward records the queue size of estimation events against the backend, for
+// balancing its pool, then emits ev.
+func (c *Client) forward(ctx context.Context, events chan<- Event, ev Event) bool {
+	if ev.Estimation != nil {
+		c.Backend.observe(*ev.Estimation)
+	}
+
+	return emit(ctx, events, ev)
+}
+
+// emit sends ev to the caller, giving up if the job has been cancelled.
+func emit(ctx context.Context, events chan<- Event, ev Event) bool {
+	select {
+	case events <- ev:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,189 @@
+package gradio
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// diffStream rebuilds the outputs of a job whose process_generating packets after the
+// first carry a diff against the outputs before, as from the sse_v2 protocol on.
+type diffStream struct {
+	data []interface{}
+}
+
+// apply returns the output block of a process_generating packet with the diffs in its
+// data replaced by the outputs they make.
+func (d *diffStream) apply(output json.RawMessage) (json.RawMessage, error) {
+	block := map[string]json.RawMessage{}
+	err := json.Unmarshal(output, &block)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(block["data"]) == 0 {
+		return output, nil
+	}
+
+	data := []interface{}{}
+	err = unmarshalNumbers(block["data"], &data)
+	if err != nil {
+		return nil, err
+	}
+
+	// The first is sent whole
+	if d.data == nil {
+		d.data = data
+		return output, nil
+	}
+
+	if len(data) != len(d.data) {
+		return nil, fmt.Errorf("diff of %d outputs for %d", len(data), len(d.data))
+	}
+
+	for i, diff := range data {
+		d.data[i], err = applyDiff(d.data[i], diff)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	block["data"], err = json.Marshal(d.data)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(block)
+}
+
+// applyDiff applies a list of [action, path, value] edits to value, as Gradio's
+// apply_diff does.
+func applyDiff(value interface{}, diff interface{}) (interface{}, error) {
+	edits, ok := diff.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("diff is %T, not a list of edits", diff)
+	}
+
+	for _, e := range edits {
+		edit, ok := e.([]interface{})
+		if !ok || len(edit) != 3 {
+			return nil, fmt.Errorf("malformed edit %v", e)
+		}
+
+		action, _ := edit[0].(string)
+		path, ok := edit[1].([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("malformed edit path %v", edit[1])
+		}
+
+		var err error
+		value, err = applyEdit(value, path, action, edit[2])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return value, nil
+}
+
+// applyEdit applies a single edit to the value at path within target, returning the
+// target edited.
+func applyEdit(target interface{}, path []interface{}, action string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		switch action {
+		case "replace":
+			return value, nil
+		case "append":
+			return appendValue(target, value)
+		}
+
+		return nil, fmt.Errorf("can't %s the whole output", action)
+	}
+
+	switch t := target.(type) {
+	case []interface{}:
+		i, err := pathIndex(path[0])
+		if err != nil {
+			return nil, err
+		}
+
+		if len(path) == 1 && action == "add" {
+			if i < 0 || i > len(t) {
+				return nil, fmt.Errorf("index %d out of range", i)
+			}
+
+			t = append(t, nil)
+			copy(t[i+1:], t[i:])
+			t[i] = value
+			return t, nil
+		}
+
+		if i < 0 || i >= len(t) {
+			return nil, fmt.Errorf("index %d out of range", i)
+		}
+
+		if len(path) == 1 && action == "delete" {
+			return append(t[:i], t[i+1:]...), nil
+		}
+
+		t[i], err = applyEdit(t[i], path[1:], action, value)
+		return t, err
+	case map[string]interface{}:
+		key := fmt.Sprint(path[0])
+
+		if len(path) == 1 {
+			switch action {
+			case "add":
+				t[key] = value
+				return t, nil
+			case "delete":
+				delete(t, key)
+				return t, nil
+			}
+		}
+
+		edited, err := applyEdit(t[key], path[1:], action, value)
+		t[key] = edited
+		return t, err
+	}
+
+	return nil, fmt.Errorf("can't follow path into %T", target)
+}
+
+// appendValue appends value to target, both strings or both lists.
+func appendValue(target interface{}, value interface{}) (interface{}, error) {
+	switch t := target.(type) {
+	case string:
+		if v, ok := value.(string); ok {
+			return t + v, nil
+		}
+	case []interface{}:
+		if v, ok := value.([]interface{}); ok {
+			return append(t, v...), nil
+		}
+	}
+
+	return nil, fmt.Errorf("can't append %T to %T", value, target)
+}
+
+func pathIndex(key interface{}) (int, error) {
+	switch k := key.(type) {
+	case json.Number:
+		i, err := k.Int64()
+		return int(i), err
+	case string:
+		return strconv.Atoi(k)
+	}
+
+	return 0, fmt.Errorf("malformed path element %v", key)
+}
+
+// unmarshalNumbers unmarshals data into v, keeping numbers as json.Number so they are
+// marshalled back unchanged.
+func unmarshalNumbers(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	return decoder.Decode(v)
+}
@@ -0,0 +1,48 @@
+package gradio
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/M-Ro/aurora-ai/internal/gradio/fake"
+)
+
+func TestDiffStream(t *testing.T) {
+	// A chat history growing a reply at a time, then being cleared
+	outputs := [][]interface{}{
+		{[]interface{}{[]interface{}{"hi", ""}}, "typing"},
+		{[]interface{}{[]interface{}{"hi", "Hello"}}, "typing"},
+		{[]interface{}{[]interface{}{"hi", "Hello there"}, []interface{}{"bye", ""}}, map[string]interface{}{"done": true}},
+		{[]interface{}{}, map[string]interface{}{"done": true, "count": 2}},
+	}
+
+	diffs := diffStream{}
+	for i, output := range outputs {
+		data := interface{}(output)
+		if i > 0 {
+			chunk := []interface{}{}
+			for n := range output {
+				chunk = append(chunk, fake.Diff(outputs[i-1][n], output[n]))
+			}
+			data = chunk
+		}
+
+		block, _ := json.Marshal(map[string]interface{}{"data": data, "is_generating": true})
+		applied, err := diffs.apply(block)
+		if err != nil {
+			t.Fatalf("output %d: %v", i, err)
+		}
+
+		got := map[string]json.RawMessage{}
+		json.Unmarshal(applied, &got)
+		want, _ := json.Marshal(output)
+		if string(got["data"]) != string(want) {
+			t.Errorf("output %d: got %s, want %s", i, got["data"], want)
+		}
+	}
+
+	_, err := diffs.apply([]byte(`{"data": [[["append", [0, 0, 1], 5]], []]}`))
+	if err == nil {
+		t.Error("expected appending a number to a string to fail")
+	}
+}
@@ -0,0 +1,72 @@
+package gradio
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/M-Ro/aurora-ai/api"
+)
+
+var (
+	ErrQueueFull = errors.New("Queue is full")
+)
+
+// UpstreamError is returned when the app raised an exception while running our job.
+type UpstreamError struct {
+	Message string
+}
+
+func (e *UpstreamError) Error() string {
+	if e.Message == "" {
+		return "Upstream error while running job"
+	}
+
+	return "Upstream error while running job: " + e.Message
+}
+
+// ProtocolError is returned when the app sends something we can't make sense of,
+// such as a malformed packet or an output block of the wrong shape.
+type ProtocolError struct {
+	Reason string
+	Err    error
+}
+
+func (e *ProtocolError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("Protocol violation: %s: %s", e.Reason, e.Err)
+	}
+
+	return "Protocol violation: " + e.Reason
+}
+
+func (e *ProtocolError) Unwrap() error {
+	return e.Err
+}
+
+// UnsupportedProtocolError is returned when the app's queue speaks a protocol we don't.
+type UnsupportedProtocolError struct {
+	Name string
+}
+
+func (e *UnsupportedProtocolError) Error() string {
+	return fmt.Sprintf("Unsupported queue protocol %q", e.Name)
+}
+
+// UnexpectedPacketError is returned when the app sends a packet which has no place
+// in the protocol at that point of the job.
+type UnexpectedPacketError struct {
+	Message api.GradioResponseMessage
+}
+
+func (e *UnexpectedPacketError) Error() string {
+	return fmt.Sprintf("Unexpected %q packet", e.Message)
+}
+
+// IsRetryable returns whether a job which failed with err may succeed if resubmitted,
+// as opposed to failing the same way again.
+func IsRetryable(err error) bool {
+	return errors.Is(err, ErrQueueFull) ||
+		errors.Is(err, ErrNoConnect) ||
+		errors.Is(err, ErrConnectionLost) ||
+		errors.Is(err, ErrJoinQueue)
+}
@@ -0,0 +1,92 @@
+package fake
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+	"time"
+)
+
+// demoReply is streamed word by word in reply to every prompt.
+const demoReply = " Hello! I'm a fake backend, so this is all I have to say."
+
+// NewDemoServer returns a server exposing the functions the default config expects of
+// text-generation-webui and AUTOMATIC1111, answering every job with canned output.
+func NewDemoServer(version string, interval time.Duration) *Server {
+	s := NewServer(version, nil)
+	s.Files["demo.png"] = demoImage()
+
+	s.Functions = []Function{
+		{
+			ElemId: "chat-parameters",
+			Run: func(data json.RawMessage) Script {
+				return Script{Output: []interface{}{}, Interval: interval}
+			},
+		},
+		{
+			ApiName: "textgen",
+			Run: func(data json.RawMessage) Script {
+				return demoTextScript(data, interval)
+			},
+		},
+		{
+			ElemId: "txt2img_generate",
+			Run: func(data json.RawMessage) Script {
+				images := []interface{}{
+					map[string]interface{}{"name": "demo.png", "is_file": true, "data": nil},
+				}
+
+				return Script{
+					Estimations: []Estimation{{Rank: 1, QueueSize: 2, RankEta: 2}, {Rank: 0, QueueSize: 1, RankEta: 1}},
+					Output:      []interface{}{images, "{}", ""},
+					Interval:    interval,
+				}
+			},
+		},
+	}
+
+	return s
+}
+
+// demoTextScript streams the demo reply after the prompt, as the webui returns the
+// whole text with every update.
+func demoTextScript(data json.RawMessage, interval time.Duration) Script {
+	prompt := ""
+
+	inputs := []interface{}{}
+	if json.Unmarshal(data, &inputs) == nil && len(inputs) > 0 {
+		prompt, _ = inputs[0].(string)
+	}
+
+	script := Script{
+		Estimations: []Estimation{{Rank: 0, QueueSize: 1, RankEta: 1}},
+		Interval:    interval,
+	}
+
+	text := prompt
+	for _, word := range strings.SplitAfter(demoReply, " ") {
+		text += word
+		script.Chunks = append(script.Chunks, []interface{}{text})
+	}
+	script.Output = []interface{}{text}
+
+	return script
+}
+
+// demoImage renders a small gradient to serve as the generated image.
+func demoImage() []byte {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for x := 0; x < 64; x++ {
+		for y := 0; y < 64; y++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 4), G: uint8(y * 4), B: 128, A: 255})
+		}
+	}
+
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+
+	return buf.Bytes()
+}
@@ -0,0 +1,677 @@
+// Package fake implements a scriptable stand-in for a Gradio app, speaking the websocket
+// and every SSE queue protocol, so the backends can be exercised without a GPU box.
+package fake
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+)
+
+// Estimation is a queue position update sent while a job waits.
+type Estimation struct {
+	Rank      int     `json:"rank"`
+	QueueSize int     `json:"queue_size"`
+	RankEta   float64 `json:"rank_eta"`
+}
+
+// Script describes how the server plays out a single job.
+type Script struct {
+	// Estimations are sent while the job is queued
+	Estimations []Estimation
+	// Chunks are each sent as the data of a process_generating packet
+	Chunks [][]interface{}
+	// Output is the data of the process_completed packet
+	Output []interface{}
+	// Error fails the job with the message as an upstream exception
+	Error string
+	// QueueFull rejects the job before it is queued
+	QueueFull bool
+	// Disconnect drops the connection after the chunks instead of completing the job
+	Disconnect bool
+	// Interval is waited between packets
+	Interval time.Duration
+}
+
+// Function is a function exposed by the fake app, its position in the server's
+// function list is its fn_index.
+type Function struct {
+	ApiName string
+	ElemId  string
+	Label   string
+	// Run builds the script for a job from the data it was submitted with
+	Run func(data json.RawMessage) Script
+}
+
+// Request is a job submitted to the server.
+type Request struct {
+	FnIndex     uint32          `json:"fn_index"`
+	SessionHash string          `json:"session_hash"`
+	Data        json.RawMessage `json:"data"`
+}
+
+type Server struct {
+	// Version is reported by /config
+	Version string
+	// Protocol is the queue protocol served & reported by /config, see ProtocolFor
+	Protocol  string
+	Functions []Function
+	// Files are served from /file=
+	Files map[string][]byte
+
+	mu       sync.Mutex
+	requests []Request
+	streams  map[string]*sseStream
+	// waiting holds the jobs of Gradio 4.0's SSE which were asked for their data
+	waiting map[string]chan Request
+	nextId  int
+}
+
+func NewServer(version string, functions []Function) *Server {
+	s := Server{
+		Version:   version,
+		Protocol:  ProtocolFor(version),
+		Functions: functions,
+		Files:     map[string][]byte{},
+		streams:   map[string]*sseStream{},
+		waiting:   map[string]chan Request{},
+	}
+
+	return &s
+}
+
+// ProtocolFor returns the queue protocol of a Gradio version, roughly as its releases
+// went: websockets up to 3, then sse, sse_v1 from 4.8, sse_v2 from 4.16 and sse_v3
+// from 4.21.
+func ProtocolFor(version string) string {
+	parts := strings.SplitN(version, ".", 3)
+	major, _ := strconv.Atoi(parts[0])
+	minor := 0
+	if len(parts) > 1 {
+		minor, _ = strconv.Atoi(parts[1])
+	}
+
+	switch {
+	case major < 4:
+		return "ws"
+	case major > 4 || minor >= 21:
+		return "sse_v3"
+	case minor >= 16:
+		return "sse_v2"
+	case minor >= 8:
+		return "sse_v1"
+	}
+
+	return "sse"
+}
+
+// Handler returns the http handler serving the app, e.g for httptest.NewServer.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/config", s.handleConfig)
+	mux.HandleFunc("/queue/join", s.handleJoin)
+	mux.HandleFunc("/queue/data", s.handleData)
+	mux.HandleFunc("/", s.handleFile)
+
+	return mux
+}
+
+// Requests returns every job submitted so far.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Request{}, s.requests...)
+}
+
+// sendsDiffs returns whether process_generating outputs after the first are sent as
+// diffs against the one before.
+func (s *Server) sendsDiffs() bool {
+	return s.Protocol == "sse_v2" || s.Protocol == "sse_v2.1" || s.Protocol == "sse_v3"
+}
+
+func (s *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
+	components := []map[string]interface{}{}
+	dependencies := []map[string]interface{}{}
+
+	for i, fn := range s.Functions {
+		id := i + 1
+		components = append(components, map[string]interface{}{
+			"id":   id,
+			"type": "button",
+			"props": map[string]interface{}{
+				"elem_id": fn.ElemId,
+				"value":   fn.Label,
+			},
+		})
+
+		var apiName interface{} = false
+		if fn.ApiName != "" {
+			apiName = fn.ApiName
+		}
+
+		dependencies = append(dependencies, map[string]interface{}{
+			"api_name": apiName,
+			"targets":  []int{id},
+			"inputs":   []int{},
+			"outputs":  []int{},
+		})
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"version":      s.Version,
+		"protocol":     s.Protocol,
+		"components":   components,
+		"dependencies": dependencies,
+	})
+}
+
+func (s *Server) handleFile(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/file=")
+	if name == r.URL.Path {
+		http.NotFound(w, r)
+		return
+	}
+
+	data, ok := s.Files[name]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Write(data)
+}
+
+// script records the request and returns the script of the function it targets.
+func (s *Server) script(req Request) (Script, error) {
+	s.mu.Lock()
+	s.requests = append(s.requests, req)
+	s.mu.Unlock()
+
+	if int(req.FnIndex) >= len(s.Functions) {
+		return Script{}, fmt.Errorf("no function at fn_index %d", req.FnIndex)
+	}
+
+	fn := s.Functions[req.FnIndex]
+	if fn.Run == nil {
+		return Script{Output: []interface{}{}}, nil
+	}
+
+	return fn.Run(req.Data), nil
+}
+
+// packet is the envelope of every packet the server sends.
+type packet struct {
+	Message string      `json:"msg"`
+	EventId string      `json:"event_id,omitempty"`
+	Output  interface{} `json:"output,omitempty"`
+	Success *bool       `json:"success,omitempty"`
+	Rank    *int        `json:"rank,omitempty"`
+	Size    *int        `json:"queue_size,omitempty"`
+	RankEta *float64    `json:"rank_eta,omitempty"`
+}
+
+func estimationPackets(script Script) []packet {
+	packets := []packet{}
+	for _, est := range script.Estimations {
+		est := est
+		packets = append(packets, packet{
+			Message: "estimation",
+			Rank:    &est.Rank,
+			Size:    &est.QueueSize,
+			RankEta: &est.RankEta,
+		})
+	}
+
+	return packets
+}
+
+// processPackets returns the packets sent once the job starts. A nil packet marks
+// where the connection is dropped. With diffs, the data of process_generating packets
+// after the first is a diff of each output against the one before.
+func processPackets(script Script, diffs bool) []*packet {
+	success := true
+	failure := false
+
+	packets := []*packet{{Message: "process_starts"}}
+	for i, chunk := range script.Chunks {
+		data := chunk
+		if diffs && i > 0 {
+			data = diffChunk(script.Chunks[i-1], chunk)
+		}
+
+		packets = append(packets, &packet{
+			Message: "process_generating",
+			Output:  map[string]interface{}{"data": data, "is_generating": true},
+			Success: &success,
+		})
+	}
+
+	switch {
+	case script.Disconnect:
+		packets = append(packets, nil)
+	case script.Error != "":
+		packets = append(packets, &packet{
+			Message: "process_completed",
+			Output:  map[string]interface{}{"error": script.Error},
+			Success: &failure,
+		})
+	default:
+		packets = append(packets, &packet{
+			Message: "process_completed",
+			Output: map[string]interface{}{
+				"data":             script.Output,
+				"is_generating":    false,
+				"duration":         0.1,
+				"average_duration": 0.1,
+			},
+			Success: &success,
+		})
+	}
+
+	return packets
+}
+
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// diffChunk returns the diff of each output of a chunk against those of the one before.
+func diffChunk(prev []interface{}, chunk []interface{}) []interface{} {
+	data := []interface{}{}
+	for i := range chunk {
+		var old interface{}
+		if i < len(prev) {
+			old = prev[i]
+		}
+
+		data = append(data, Diff(old, chunk[i]))
+	}
+
+	return data
+}
+
+// Diff returns the [action, path, value] edits turning old into new, as Gradio's
+// utils.diff does.
+func Diff(old interface{}, new interface{}) []interface{} {
+	edits := []interface{}{}
+
+	var compare func(path []interface{}, old interface{}, new interface{})
+	compare = func(path []interface{}, old interface{}, new interface{}) {
+		at := func(key interface{}) []interface{} {
+			return append(append([]interface{}{}, path...), key)
+		}
+
+		if reflect.DeepEqual(old, new) {
+			return
+		}
+
+		switch o := old.(type) {
+		case string:
+			if n, ok := new.(string); ok && strings.HasPrefix(n, o) {
+				edits = append(edits, []interface{}{"append", path, n[len(o):]})
+				return
+			}
+		case []interface{}:
+			if n, ok := new.([]interface{}); ok {
+				for i := 0; i < len(o) && i < len(n); i++ {
+					compare(at(i), o[i], n[i])
+				}
+				for i := len(o) - 1; i >= len(n); i-- {
+					edits = append(edits, []interface{}{"delete", at(i), nil})
+				}
+				for i := len(o); i < len(n); i++ {
+					edits = append(edits, []interface{}{"add", at(i), n[i]})
+				}
+				return
+			}
+		case map[string]interface{}:
+			if n, ok := new.(map[string]interface{}); ok {
+				for key, value := range o {
+					if _, ok := n[key]; !ok {
+						edits = append(edits, []interface{}{"delete", at(key), nil})
+					} else {
+						compare(at(key), value, n[key])
+					}
+				}
+				for key, value := range n {
+					if _, ok := o[key]; !ok {
+						edits = append(edits, []interface{}{"add", at(key), value})
+					}
+				}
+				return
+			}
+		}
+
+		edits = append(edits, []interface{}{"replace", path, new})
+	}
+
+	compare([]interface{}{}, old, new)
+	return edits
+}
+
+func (s *Server) handleJoin(w http.ResponseWriter, r *http.Request) {
+	switch s.Protocol {
+	case "ws":
+	case "sse":
+		s.handleSSEStream(w, r)
+		return
+	default:
+		s.handleSSEJoin(w, r)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logrus.Error("fake: upgrade: ", err)
+		return
+	}
+	defer conn.Close()
+
+	err = s.serveWebsocket(conn)
+	if err != nil {
+		logrus.Error("fake: ", err)
+	}
+}
+
+func (s *Server) serveWebsocket(conn *websocket.Conn) error {
+	req := Request{}
+
+	err := conn.WriteJSON(packet{Message: "send_hash"})
+	if err != nil {
+		return err
+	}
+
+	err = conn.ReadJSON(&req)
+	if err != nil {
+		return err
+	}
+
+	err = conn.WriteJSON(packet{Message: "send_data"})
+	if err != nil {
+		return err
+	}
+
+	err = conn.ReadJSON(&req)
+	if err != nil {
+		return err
+	}
+
+	script, err := s.script(req)
+	if err != nil {
+		return err
+	}
+
+	if script.QueueFull {
+		return conn.WriteJSON(packet{Message: "queue_full"})
+	}
+
+	for _, p := range estimationPackets(script) {
+		time.Sleep(script.Interval)
+		err = conn.WriteJSON(p)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, p := range processPackets(script, false) {
+		time.Sleep(script.Interval)
+		if p == nil {
+			return nil
+		}
+
+		err = conn.WriteJSON(p)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sseStream queues the packets of a session's jobs until /queue/data reads them.
+type sseStream struct {
+	packets chan *packet
+	pending int
+}
+
+func (s *Server) stream(sessionHash string) *sseStream {
+	stream, ok := s.streams[sessionHash]
+	if !ok {
+		stream = &sseStream{packets: make(chan *packet, 1024)}
+		s.streams[sessionHash] = stream
+	}
+
+	return stream
+}
+
+func (s *Server) handleSSEJoin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	req := Request{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	script, err := s.script(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if script.QueueFull {
+		http.Error(w, "Queue is full.", http.StatusServiceUnavailable)
+		return
+	}
+
+	s.mu.Lock()
+	s.nextId++
+	eventId := fmt.Sprintf("event-%d", s.nextId)
+	stream := s.stream(req.SessionHash)
+	stream.pending++
+	s.mu.Unlock()
+
+	go func() {
+		for _, p := range estimationPackets(script) {
+			p := p
+			time.Sleep(script.Interval)
+			p.EventId = eventId
+			stream.packets <- &p
+		}
+
+		for _, p := range processPackets(script, s.sendsDiffs()) {
+			time.Sleep(script.Interval)
+			if p != nil {
+				p.EventId = eventId
+			}
+			stream.packets <- p
+		}
+
+		s.mu.Lock()
+		stream.pending--
+		s.mu.Unlock()
+	}()
+
+	writeJSON(w, map[string]string{"event_id": eventId})
+}
+
+// handleSSEStream serves the event stream of a job on Gradio 4.0's SSE, asking for the
+// job's data to be posted to /queue/data before playing its script.
+func (s *Server) handleSSEStream(w http.ResponseWriter, r *http.Request) {
+	flusher, _ := w.(http.Flusher)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(p *packet) {
+		data, err := json.Marshal(p)
+		if err != nil {
+			return
+		}
+
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	s.mu.Lock()
+	s.nextId++
+	eventId := fmt.Sprintf("event-%d", s.nextId)
+	posted := make(chan Request, 1)
+	s.waiting[eventId] = posted
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.waiting, eventId)
+		s.mu.Unlock()
+	}()
+
+	send(&packet{Message: "send_data", EventId: eventId})
+
+	var req Request
+	select {
+	case req = <-posted:
+	case <-r.Context().Done():
+		return
+	}
+
+	script, err := s.script(req)
+	if err != nil {
+		logrus.Error("fake: ", err)
+		return
+	}
+
+	if script.QueueFull {
+		send(&packet{Message: "queue_full", EventId: eventId})
+		return
+	}
+
+	for _, p := range estimationPackets(script) {
+		p := p
+		if !pause(r, script.Interval) {
+			return
+		}
+		p.EventId = eventId
+		send(&p)
+	}
+
+	for _, p := range processPackets(script, false) {
+		if !pause(r, script.Interval) || p == nil {
+			return
+		}
+
+		p.EventId = eventId
+		send(p)
+	}
+}
+
+// pause waits out the interval, returning false if the client went away meanwhile.
+func pause(r *http.Request, interval time.Duration) bool {
+	select {
+	case <-time.After(interval):
+		return true
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+// handleSSEData receives the data of a job on Gradio 4.0's SSE.
+func (s *Server) handleSSEData(w http.ResponseWriter, r *http.Request) {
+	req := struct {
+		Request
+		EventId string `json:"event_id"`
+	}{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	posted, ok := s.waiting[req.EventId]
+	s.mu.Unlock()
+
+	if !ok {
+		http.Error(w, "unknown event_id", http.StatusNotFound)
+		return
+	}
+
+	posted <- req.Request
+	writeJSON(w, map[string]interface{}{"msg": "success"})
+}
+
+func (s *Server) handleData(w http.ResponseWriter, r *http.Request) {
+	if s.Protocol == "sse" {
+		s.handleSSEData(w, r)
+		return
+	}
+
+	s.mu.Lock()
+	stream := s.stream(r.URL.Query().Get("session_hash"))
+	s.mu.Unlock()
+
+	flusher, _ := w.(http.Flusher)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.WriteHeader(http.StatusOK)
+
+	for {
+		var p *packet
+		select {
+		case p = <-stream.packets:
+		case <-r.Context().Done():
+			return
+		}
+
+		// Dropping the connection
+		if p == nil {
+			return
+		}
+
+		data, err := json.Marshal(p)
+		if err != nil {
+			return
+		}
+
+		fmt.Fprintf(w, "data: %s\n\n", data)
+
+		// Like Gradio, close the stream once the session has nothing left in flight
+		s.mu.Lock()
+		idle := stream.pending == 0 && len(stream.packets) == 0
+		s.mu.Unlock()
+
+		if idle {
+			fmt.Fprint(w, "data: {\"msg\": \"close_stream\"}\n\n")
+		}
+
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		if idle {
+			return
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		logrus.Error("fake: ", err)
+	}
+}
@@ -0,0 +1,51 @@
+package gradio
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	ErrDownloadFailed = errors.New("Failed to GET file")
+	ErrReadFailed     = errors.New("Failed to read response bytes")
+)
+
+// FetchFile downloads a file the app has written to its temp dir, such as a
+// generated image, through the backend's /file= route.
+func (c *Client) FetchFile(ctx context.Context, path string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.Backend.URL("/file="+path), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := c.Backend.HTTPClient().Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		logrus.Error(err)
+		return nil, ErrDownloadFailed
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		logRejectedResponse(res)
+		return nil, ErrDownloadFailed
+	}
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		return nil, ErrReadFailed
+	}
+
+	return data, nil
+}
@@ -0,0 +1,378 @@
+package gradio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+var (
+	ErrNoBackends = errors.New("No backend hosts configured")
+)
+
+// Strategy is how a pool picks which backend runs the next job.
+type Strategy string
+
+const (
+	// RoundRobin spreads jobs between the backends in proportion to their weight
+	RoundRobin Strategy = "round_robin"
+	// LeastQueued sends jobs to the backend with the shortest queue
+	LeastQueued Strategy = "least_queued"
+)
+
+// healthCheckTimeout bounds how long a backend has to answer a health check.
+const healthCheckTimeout = 5 * time.Second
+
+// PoolHost is a single host of a pool.
+type PoolHost struct {
+	Host   string `mapstructure:"host"`
+	Weight int    `mapstructure:"weight"`
+}
+
+// PoolSettings configures a pool, the connection settings are shared by every host.
+// A single host may be given through Host rather than Hosts.
+type PoolSettings struct {
+	Host                string        `mapstructure:"host"`
+	Hosts               []PoolHost    `mapstructure:"hosts"`
+	Scheme              string        `mapstructure:"scheme"`
+	TLS                 TLSConfig     `mapstructure:"tls"`
+	Auth                AuthConfig    `mapstructure:"auth"`
+	Balancing           Strategy      `mapstructure:"balancing"`
+	HealthCheckInterval time.Duration `mapstructure:"health_check_interval"`
+}
+
+func (s *PoolSettings) hosts() []PoolHost {
+	if len(s.Hosts) == 0 && s.Host != "" {
+		return []PoolHost{{Host: s.Host, Weight: 1}}
+	}
+
+	return s.Hosts
+}
+
+// Pool is a set of backends running the same app, between which jobs are balanced.
+type Pool struct {
+	Strategy Strategy
+
+	mu      sync.Mutex
+	members []*poolMember
+	stop    chan struct{}
+}
+
+type poolMember struct {
+	backend *Backend
+	weight  int
+	// current is the member's running weight for smooth weighted round robin
+	current int
+}
+
+func NewPool(strategy Strategy) *Pool {
+	p := Pool{
+		Strategy: strategy,
+	}
+
+	return &p
+}
+
+// Add adds a backend to the pool. Weights below 1 count as 1.
+func (p *Pool) Add(backend *Backend, weight int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if weight < 1 {
+		weight = 1
+	}
+
+	p.members = append(p.members, &poolMember{backend: backend, weight: weight})
+}
+
+// Backends returns every backend in the pool.
+func (p *Pool) Backends() []*Backend {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	backends := []*Backend{}
+	for _, m := range p.members {
+		backends = append(backends, m.backend)
+	}
+
+	return backends
+}
+
+// Candidates returns the backends in the order they should be tried for the next job.
+// Backends which are down come last, so they are still tried if nothing else is up.
+func (p *Pool) Candidates() []*Backend {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	up := []*poolMember{}
+	down := []*poolMember{}
+	for _, m := range p.members {
+		if m.backend.Healthy() {
+			up = append(up, m)
+		} else {
+			down = append(down, m)
+		}
+	}
+
+	if p.Strategy == LeastQueued {
+		sort.SliceStable(up, func(i, j int) bool {
+			li, lj := up[i].backend.load(), up[j].backend.load()
+			if li != lj {
+				return li < lj
+			}
+
+			return up[i].weight > up[j].weight
+		})
+	} else if len(up) > 0 {
+		// Smooth weighted round robin, as used by nginx
+		total := 0
+		best := 0
+		for i, m := range up {
+			m.current += m.weight
+			total += m.weight
+
+			if m.current > up[best].current {
+				best = i
+			}
+		}
+		up[best].current -= total
+
+		up = append([]*poolMember{up[best]}, append(up[:best:best], up[best+1:]...)...)
+	}
+
+	candidates := []*Backend{}
+	for _, m := range append(up, down...) {
+		candidates = append(candidates, m.backend)
+	}
+
+	return candidates
+}
+
+// Run calls job with each candidate backend in turn until one can be reached, marking
+// those which can't as down. The error of the last attempt is returned.
+func (p *Pool) Run(job func(backend *Backend) error) error {
+	candidates := p.Candidates()
+	if len(candidates) == 0 {
+		return ErrNoBackends
+	}
+
+	var err error
+	for _, backend := range candidates {
+		backend.stats.mu.Lock()
+		backend.stats.inflight++
+		backend.stats.mu.Unlock()
+
+		err = job(backend)
+
+		backend.stats.mu.Lock()
+		backend.stats.inflight--
+		backend.stats.mu.Unlock()
+
+		if !unreachable(err) {
+			backend.setHealthy(true)
+			return err
+		}
+
+		backend.setHealthy(false)
+		logrus.Warnf("Could not reach %s, failing over", backend.Host)
+	}
+
+	return err
+}
+
+// unreachable returns whether err means the backend couldn't be reached at all, so
+// the job can safely be tried elsewhere.
+func unreachable(err error) bool {
+	return errors.Is(err, ErrNoConnect) || errors.Is(err, ErrFetchConfig)
+}
+
+// ResolveEndpoints resolves the endpoints on every backend of the pool. An unreachable
+// backend is marked down and resolved again on first use, but one missing endpoints
+// fails immediately. An error is only returned for unreachable backends if none answer.
+func (p *Pool) ResolveEndpoints(ctx context.Context, endpoints EndpointSet) error {
+	var lastErr error
+	resolved := 0
+
+	for _, backend := range p.Backends() {
+		err := ResolveEndpoints(ctx, backend, endpoints)
+
+		var missing *MissingEndpointsError
+		if errors.As(err, &missing) {
+			return err
+		}
+
+		if err != nil {
+			logrus.Warnf("Could not resolve endpoints on %s: %s", backend.Host, err)
+			backend.setHealthy(false)
+			lastErr = err
+			continue
+		}
+
+		backend.setHealthy(true)
+		resolved++
+	}
+
+	if resolved == 0 {
+		return lastErr
+	}
+
+	return nil
+}
+
+// CheckHealth fetches the app config of every backend, marking each up or down. The
+// endpoints of a backend coming back up, or whose app changed, are resolved again.
+func (p *Pool) CheckHealth() {
+	for _, backend := range p.Backends() {
+		recovering := !backend.Healthy()
+
+		// A restarted app will have forgotten our login
+		var err error
+		if recovering {
+			err = backend.Login()
+		}
+
+		if err == nil {
+			ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+			var cfg *AppConfig
+			cfg, err = FetchAppConfig(ctx, backend)
+			cancel()
+
+			if err == nil {
+				err = refreshAppConfig(backend.Host, cfg, recovering)
+				if err != nil {
+					logrus.Error(err)
+				}
+			}
+		}
+
+		backend.setHealthy(err == nil)
+	}
+}
+
+// StartHealthChecks checks the health of the pool every interval until Stop is called.
+func (p *Pool) StartHealthChecks(interval time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.stop != nil {
+		return
+	}
+
+	stop := make(chan struct{})
+	p.stop = stop
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				p.CheckHealth()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends the pool's health checks.
+func (p *Pool) Stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.stop != nil {
+		close(p.stop)
+		p.stop = nil
+	}
+}
+
+// pools is a singleton map of every pool loaded from config
+var (
+	poolsMu sync.Mutex
+	pools   = map[string]*loadedPool{}
+)
+
+type loadedPool struct {
+	pool     *Pool
+	settings PoolSettings
+}
+
+// GetPool returns the pool configured under key, e.g "llm", loading it on first use or
+// when any of its settings have changed.
+func GetPool(key string) (*Pool, error) {
+	settings := PoolSettings{}
+	err := viper.UnmarshalKey(key, &settings)
+	if err != nil {
+		return nil, err
+	}
+
+	poolsMu.Lock()
+	defer poolsMu.Unlock()
+
+	loaded, ok := pools[key]
+	if ok && reflect.DeepEqual(loaded.settings, settings) {
+		return loaded.pool, nil
+	}
+
+	pool, err := newPoolFromSettings(&settings)
+	if err != nil {
+		return nil, err
+	}
+
+	if ok {
+		loaded.pool.Stop()
+	}
+	pools[key] = &loadedPool{pool: pool, settings: settings}
+
+	return pool, nil
+}
+
+func newPoolFromSettings(settings *PoolSettings) (*Pool, error) {
+	strategy := settings.Balancing
+	if strategy == "" {
+		strategy = RoundRobin
+	}
+
+	if strategy != RoundRobin && strategy != LeastQueued {
+		return nil, fmt.Errorf("Unknown balancing strategy %q", strategy)
+	}
+
+	pool := NewPool(strategy)
+
+	for _, host := range settings.hosts() {
+		backend := Backend{
+			Host:   host.Host,
+			Scheme: settings.Scheme,
+			TLS:    settings.TLS,
+			Auth:   settings.Auth,
+		}
+
+		err := backend.init()
+		if err != nil {
+			return nil, err
+		}
+
+		// The backend logs in again when it comes back up
+		err = backend.Login()
+		if err != nil {
+			logrus.Warnf("Could not log in to %s: %s", host.Host, err)
+			backend.setHealthy(false)
+		}
+
+		pool.Add(&backend, host.Weight)
+	}
+
+	if settings.HealthCheckInterval > 0 {
+		pool.StartHealthChecks(settings.HealthCheckInterval)
+	}
+
+	return pool, nil
+}
@@ -0,0 +1,148 @@
+package gradio
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func newTestBackend(t *testing.T, host string) *Backend {
+	t.Helper()
+
+	backend, err := NewBackend(host, "http", TLSConfig{}, AuthConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return backend
+}
+
+func TestPoolRoundRobin(t *testing.T) {
+	a := newTestBackend(t, "a")
+	b := newTestBackend(t, "b")
+
+	pool := NewPool(RoundRobin)
+	pool.Add(a, 2)
+	pool.Add(b, 1)
+
+	picked := []string{}
+	for i := 0; i < 6; i++ {
+		picked = append(picked, pool.Candidates()[0].Host)
+	}
+
+	if got := strings.Join(picked, ""); got != "abaaba" {
+		t.Errorf("picked %q, want %q", got, "abaaba")
+	}
+
+	// A down backend is only tried last
+	a.setHealthy(false)
+	for i := 0; i < 3; i++ {
+		candidates := pool.Candidates()
+		if candidates[0] != b || candidates[1] != a {
+			t.Fatalf("down backend not tried last")
+		}
+	}
+}
+
+func TestPoolLeastQueued(t *testing.T) {
+	a := newTestBackend(t, "a")
+	b := newTestBackend(t, "b")
+
+	pool := NewPool(LeastQueued)
+	pool.Add(a, 1)
+	pool.Add(b, 1)
+
+	a.observe(Estimation{QueueSize: 5})
+	b.observe(Estimation{QueueSize: 1})
+
+	if pool.Candidates()[0] != b {
+		t.Errorf("expected the shorter queue first")
+	}
+}
+
+func TestPoolFailover(t *testing.T) {
+	// Nothing listens on a closed server's address
+	dead := httptest.NewServer(nil)
+	dead.Close()
+
+	down := newTestBackend(t, strings.TrimPrefix(dead.URL, "http://"))
+	up := newTestBackend(t, "up")
+
+	pool := NewPool(RoundRobin)
+	pool.Add(down, 2)
+	pool.Add(up, 1)
+
+	tried := []*Backend{}
+	err := pool.Run(func(backend *Backend) error {
+		tried = append(tried, backend)
+		if backend == down {
+			return ErrNoConnect
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tried) != 2 || tried[1] != up {
+		t.Errorf("expected to fail over to the healthy backend")
+	}
+
+	if down.Healthy() || !up.Healthy() {
+		t.Errorf("expected the unreachable backend to be marked down")
+	}
+
+	pool.CheckHealth()
+	if down.Healthy() {
+		t.Errorf("expected the health check to keep the backend down")
+	}
+}
+
+func TestGetPoolReload(t *testing.T) {
+	viper.Set("pooltest", map[string]interface{}{
+		"hosts":  []map[string]interface{}{{"host": "a", "weight": 1}},
+		"scheme": "http",
+	})
+	defer viper.Set("pooltest", nil)
+
+	pool, err := GetPool("pooltest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Stop()
+
+	if again, _ := GetPool("pooltest"); again != pool {
+		t.Errorf("pool reloaded without its settings changing")
+	}
+
+	// Any setting of its backends changing reloads the pool
+	changes := map[string]interface{}{
+		"pooltest.scheme":                   "https",
+		"pooltest.tls.insecure_skip_verify": true,
+		"pooltest.auth.headers":             map[string]string{"X-Key": "secret"},
+		"pooltest.health_check_interval":    "1h",
+	}
+	for setting, value := range changes {
+		viper.Set(setting, value)
+
+		reloaded, err := GetPool("pooltest")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer reloaded.Stop()
+
+		if reloaded == pool {
+			t.Errorf("pool not reloaded after %s changed", setting)
+		}
+		pool = reloaded
+	}
+
+	backend := pool.Backends()[0]
+	if backend.Scheme != "https" || !backend.TLS.InsecureSkipVerify || backend.Auth.Headers["X-Key"] != "secret" {
+		t.Errorf("reloaded backend has settings %+v", backend)
+	}
+}
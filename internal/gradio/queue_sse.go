@@ -0,0 +1,321 @@
+package gradio
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/M-Ro/aurora-ai/api"
+	"github.com/M-Ro/aurora-ai/internal/helpers"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	ErrJoinQueue = errors.New("Failed to join queue")
+)
+
+// cancelTimeout bounds telling the app to drop a job we no longer wait on.
+const cancelTimeout = 10 * time.Second
+
+var (
+	// sessionStreams holds a slot per backend & session, as from SSE v1 the events of
+	// all a session's jobs come down one /queue/data stream, which jobs reading it at
+	// once would take each other's packets from
+	sessionStreamsMu sync.Mutex
+	sessionStreams   = map[string]chan struct{}{}
+)
+
+// claimStream waits until no other job of the session reads its event stream on the
+// backend, returning the func to release it with.
+func (c *Client) claimStream(ctx context.Context) (func(), error) {
+	key := c.Backend.Host + " " + c.Session.SessionHash
+
+	sessionStreamsMu.Lock()
+	slot, ok := sessionStreams[key]
+	if !ok {
+		slot = make(chan struct{}, 1)
+		sessionStreams[key] = slot
+	}
+	sessionStreamsMu.Unlock()
+
+	select {
+	case slot <- struct{}{}:
+		return func() { <-slot }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// sseJob is a job running over one of the SSE queues used from Gradio 4.
+type sseJob struct {
+	client   *Client
+	protocol Protocol
+	fnIndex  uint32
+	data     interface{}
+	// eventId is the app's id of the job, which Gradio 4.0's SSE only sends once it
+	// asks for the data
+	eventId string
+	diffs   diffStream
+}
+
+// submitSSE runs a job over the SSE queue used from Gradio 4. From SSE v1 the job is
+// posted to /queue/join and its progress read from the session's /queue/data event
+// stream, one job of the session at a time. Before that the job's own stream is opened
+// from /queue/join and its data posted to /queue/data when the app asks for it.
+func (c *Client) submitSSE(ctx context.Context, protocol Protocol, fnIndex uint32, data interface{}) (_ <-chan Event, err error) {
+	job := &sseJob{
+		client:   c,
+		protocol: protocol,
+		fnIndex:  fnIndex,
+		data:     data,
+	}
+
+	release := func() {}
+	if protocol != ProtocolSSE {
+		release, err = c.claimStream(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// The stream is released by the job once it has been read to the end
+	defer func() {
+		if err != nil {
+			release()
+		}
+	}()
+
+	path := "/queue/join?" + url.Values{
+		"fn_index":     {fmt.Sprint(fnIndex)},
+		"session_hash": {c.Session.SessionHash},
+	}.Encode()
+
+	if protocol != ProtocolSSE {
+		err := job.join(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		path = "/queue/data?session_hash=" + url.QueryEscape(c.Session.SessionHash)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.Backend.URL(path), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := c.Backend.HTTPClient().Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		logrus.Error(err)
+		return nil, ErrNoConnect
+	}
+
+	if stream.StatusCode == http.StatusServiceUnavailable {
+		stream.Body.Close()
+		return nil, ErrQueueFull
+	}
+
+	if stream.StatusCode != http.StatusOK {
+		logRejectedResponse(stream)
+		stream.Body.Close()
+		return nil, ErrNoConnect
+	}
+
+	events := make(chan Event)
+	go job.run(ctx, stream.Body, events, release)
+
+	return events, nil
+}
+
+// join posts the job to /queue/join, as from SSE v1.
+func (j *sseJob) join(ctx context.Context) error {
+	joined := api.QueueJoinResponse{}
+	err := j.post(ctx, "/queue/join", api.QueueJoinRequest{
+		SessionHash: j.client.Session.SessionHash,
+		FnIndex:     j.fnIndex,
+		Data:        j.data,
+	}, &joined)
+	if err != nil {
+		return err
+	}
+
+	j.eventId = joined.EventId
+	return nil
+}
+
+// sendData posts the job's data to /queue/data, as Gradio 4.0's SSE asks for it.
+func (j *sseJob) sendData(ctx context.Context, eventId string) error {
+	j.eventId = eventId
+
+	return j.post(ctx, "/queue/data", api.QueueDataRequest{
+		SessionHash: j.client.Session.SessionHash,
+		FnIndex:     j.fnIndex,
+		Data:        j.data,
+		EventId:     eventId,
+	}, nil)
+}
+
+func (j *sseJob) post(ctx context.Context, path string, body interface{}, response interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, j.client.Backend.URL(path), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := j.client.Backend.HTTPClient().Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		logrus.Error(err)
+		return ErrNoConnect
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusServiceUnavailable {
+		return ErrQueueFull
+	}
+
+	if res.StatusCode != http.StatusOK {
+		logRejectedResponse(res)
+		return ErrJoinQueue
+	}
+
+	if response == nil {
+		return nil
+	}
+
+	err = json.NewDecoder(res.Body).Decode(response)
+	if err != nil {
+		return &ProtocolError{Reason: "malformed " + path + " response", Err: err}
+	}
+
+	return nil
+}
+
+func (j *sseJob) run(ctx context.Context, stream io.ReadCloser, events chan<- Event, release func()) {
+	defer close(events)
+	defer release()
+	defer stream.Close()
+
+	// Whether a final event has already been sent to the caller
+	finished := false
+	fail := func(err error) bool {
+		emit(ctx, events, Event{Err: err})
+		finished = true
+		return false
+	}
+
+	err := helpers.ReadEventStream(stream, func(message []byte) bool {
+		logrus.Debug("sse recv: ", string(message))
+
+		packet := queuePacket{}
+		err := json.Unmarshal(message, &packet)
+		if err != nil {
+			logrus.Error("Failed to unmarshal response: ", err)
+			return fail(&ProtocolError{Reason: "malformed packet", Err: err})
+		}
+
+		if packet.Message == api.MsgCloseStream {
+			return false
+		}
+
+		// Gradio 4.0's stream is the job's own, the later ones are shared by every job
+		// in the session
+		if j.protocol != ProtocolSSE && packet.EventId != "" && packet.EventId != j.eventId {
+			return true
+		}
+
+		if packet.Message == api.MsgSendData && j.protocol == ProtocolSSE {
+			err = j.sendData(ctx, packet.EventId)
+			if err != nil {
+				return fail(err)
+			}
+
+			return true
+		}
+
+		if packet.Message == api.MsgProcessGenerating && j.protocol == ProtocolSSEv2 && len(packet.Output) > 0 {
+			packet.Output, err = j.diffs.apply(packet.Output)
+			if err != nil {
+				return fail(&ProtocolError{Reason: "malformed output diff", Err: err})
+			}
+		}
+
+		ev, final := packet.result()
+		if ev != nil && !j.client.forward(ctx, events, *ev) {
+			return false
+		}
+
+		finished = final
+		return !finished
+	})
+
+	// The request context closes the stream for us, but the job carries on unless
+	// the app is told to drop it. That's done in the background so a hung app can't
+	// keep the caller waiting.
+	if ctx.Err() != nil {
+		if j.eventId != "" {
+			go j.client.cancelSSE(j.fnIndex, j.eventId)
+		}
+
+		return
+	}
+
+	if err != nil {
+		logrus.Error("sse read: ", err)
+	}
+
+	if !finished {
+		emit(ctx, events, Event{Err: ErrConnectionLost})
+	}
+}
+
+// cancelSSE asks the app to stop a job we are no longer waiting on.
+func (c *Client) cancelSSE(fnIndex uint32, eventId string) {
+	body, err := json.Marshal(map[string]interface{}{"event_id": eventId, "fn_index": fnIndex})
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cancelTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.Backend.URL("/reset"), bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := c.Backend.HTTPClient().Do(req)
+	if err != nil {
+		logrus.Debug("Failed cancelling job: ", err)
+		return
+	}
+
+	res.Body.Close()
+}
+
+// logRejectedResponse logs the status & start of the body of a failed request.
+func logRejectedResponse(res *http.Response) {
+	buf := make([]byte, 4096)
+	n, _ := io.ReadFull(res.Body, buf)
+	logrus.Error(res.Status + string(buf[:n]))
+}
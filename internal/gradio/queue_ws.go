@@ -0,0 +1,110 @@
+package gradio
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/M-Ro/aurora-ai/api"
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+)
+
+// submitWebsocket runs a job over the websocket queue used up to Gradio 3, where the
+// server asks for the session hash and data once it is ready to accept them.
+func (c *Client) submitWebsocket(ctx context.Context, fnIndex uint32, data interface{}) (<-chan Event, error) {
+	conn := NewAPIConnection()
+
+	err := conn.Connect(ctx, c.Backend)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan Event)
+	go c.runWebsocket(ctx, conn, fnIndex, data, events)
+
+	return events, nil
+}
+
+func (c *Client) runWebsocket(
+	ctx context.Context,
+	conn *APIConnection,
+	fnIndex uint32,
+	data interface{},
+	events chan<- Event,
+) {
+	defer close(events)
+	defer conn.Disconnect()
+
+	// Closing the socket is the only way to unblock ReadMessage once cancelled
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	for {
+		_, message, err := conn.Ws.ReadMessage()
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+
+			logrus.Error("ws read: ", err)
+			emit(ctx, events, Event{Err: ErrConnectionLost})
+			return
+		}
+
+		logrus.Debug("ws recv: ", string(message))
+
+		packet := queuePacket{}
+		err = json.Unmarshal(message, &packet)
+		if err != nil {
+			logrus.Error("Failed to unmarshal response: ", err)
+			emit(ctx, events, Event{Err: &ProtocolError{Reason: "malformed packet", Err: err}})
+			return
+		}
+
+		switch packet.Message {
+		case api.MsgSendHash:
+			err = c.send(conn, api.SendHashRequest{
+				SessionHash: c.Session.SessionHash,
+				FnIndex:     fnIndex,
+			})
+		case api.MsgSendData:
+			err = c.send(conn, api.SendInferenceDataRequest{
+				SessionHash: c.Session.SessionHash,
+				FnIndex:     fnIndex,
+				Data:        data,
+			})
+		default:
+			ev, final := packet.result()
+			if ev != nil && !c.forward(ctx, events, *ev) {
+				return
+			}
+
+			if final {
+				return
+			}
+		}
+
+		if err != nil {
+			emit(ctx, events, Event{Err: err})
+			return
+		}
+	}
+}
+
+func (c *Client) send(conn *APIConnection, v interface{}) error {
+	bytes, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	logrus.Debug("Send Message " + string(bytes))
+
+	return conn.Ws.WriteMessage(websocket.TextMessage, bytes)
+}
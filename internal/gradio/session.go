@@ -1,15 +1,21 @@
 package gradio
 
 import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
 	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
 )
 
 type Session struct {
 	SessionHash string `json:"session_hash"`
 }
 
-var globalSession *Session = nil
-
 func newSession() *Session {
 	s := Session{
 		SessionHash: quickHash(),
@@ -18,16 +24,149 @@ func newSession() *Session {
 	return &s
 }
 
-// TODO: Serialize the hash for future use if the bot restarts, so we can maintain
-// context.
 func quickHash() string {
 	return (uuid.New()).String()
 }
 
-func GetSession() *Session {
-	if globalSession == nil {
-		globalSession = newSession()
+// SessionStore hands out a session per conversation, e.g a discord channel or thread,
+// so the server side history of each conversation is kept apart. The sessions are
+// written to disk so they survive a restart of the bot.
+type SessionStore struct {
+	mu       sync.Mutex
+	path     string
+	sessions map[string]*Session
+}
+
+func NewSessionStore(path string) *SessionStore {
+	s := SessionStore{
+		path:     path,
+		sessions: map[string]*Session{},
+	}
+
+	return &s
+}
+
+// Load restores the sessions from disk. A missing file is not an error.
+func (s *SessionStore) Load() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.path == "" {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
 	}
 
-	return globalSession
+	sessions := map[string]*Session{}
+	err = json.Unmarshal(data, &sessions)
+	if err != nil {
+		return err
+	}
+
+	s.sessions = sessions
+
+	return nil
+}
+
+// Get returns the session for the conversation with key, allocating a new one if needed.
+func (s *SessionStore) Get(key string) *Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[key]
+	if ok {
+		return session
+	}
+
+	session = newSession()
+	s.sessions[key] = session
+	s.save()
+
+	return session
+}
+
+// Reset replaces the session of a conversation, dropping its server side history.
+func (s *SessionStore) Reset(key string) *Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session := newSession()
+	s.sessions[key] = session
+	s.save()
+
+	return session
+}
+
+// Len returns the number of sessions in the store.
+func (s *SessionStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.sessions)
+}
+
+// save writes the sessions to disk, s.mu must be held by the caller.
+// Failures are only logged as the session remains usable until the bot restarts.
+func (s *SessionStore) save() {
+	if s.path == "" {
+		return
+	}
+
+	data, err := json.MarshalIndent(s.sessions, "", "  ")
+	if err != nil {
+		logrus.Error("Failed marshalling sessions: ", err)
+		return
+	}
+
+	// Write to a temp file first so a crash can't leave a truncated store behind
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		logrus.Error("Failed saving sessions: ", err)
+		return
+	}
+
+	_, err = tmp.Write(data)
+	closeErr := tmp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.path)
+	}
+
+	if err != nil {
+		os.Remove(tmp.Name())
+		logrus.Error("Failed saving sessions: ", err)
+	}
+}
+
+var (
+	globalStoreOnce sync.Once
+	globalStore     *SessionStore
+)
+
+// GetSessionStore returns the session store, restoring it from gradio.session_file
+// the first time it is requested.
+func GetSessionStore() *SessionStore {
+	globalStoreOnce.Do(func() {
+		globalStore = NewSessionStore(viper.GetString("gradio.session_file"))
+
+		err := globalStore.Load()
+		if err != nil {
+			logrus.Error("Failed restoring sessions, starting afresh: ", err)
+		}
+	})
+
+	return globalStore
+}
+
+// GetSession returns the session of the conversation with key.
+func GetSession(key string) *Session {
+	return GetSessionStore().Get(key)
 }
@@ -0,0 +1,54 @@
+package gradio
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestSessionStoreRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.json")
+
+	store := NewSessionStore(path)
+	err := store.Load()
+	if err != nil {
+		t.Fatalf("a missing store should load empty, got %v", err)
+	}
+
+	a := store.Get("a").SessionHash
+	b := store.Get("b").SessionHash
+	if a == b || store.Get("a").SessionHash != a {
+		t.Fatalf("expected a distinct, stable session per conversation")
+	}
+
+	reset := store.Reset("b").SessionHash
+	if reset == b {
+		t.Errorf("reset kept the session")
+	}
+
+	// The bot restarts
+	store = NewSessionStore(path)
+	err = store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if store.Len() != 2 || store.Get("a").SessionHash != a || store.Get("b").SessionHash != reset {
+		t.Errorf("sessions weren't restored")
+	}
+}
+
+func TestSessionStoreCorrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.json")
+	ioutil.WriteFile(path, []byte("{not json"), 0644)
+
+	store := NewSessionStore(path)
+	if store.Load() == nil {
+		t.Error("expected a corrupt store to fail loading")
+	}
+
+	// It still hands out sessions
+	if store.Get("a").SessionHash == "" {
+		t.Error("no session handed out")
+	}
+}
@@ -0,0 +1,53 @@
+package helpers
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+// maxEventSize bounds a single server-sent event. Streaming backends resend the
+// whole output with every event, so this has to be generous.
+const maxEventSize = 16 * 1024 * 1024
+
+// ReadEventStream reads a text/event-stream body, calling onData with the data of each
+// event. Reading stops when the stream ends or onData returns false.
+func ReadEventStream(r io.Reader, onData func(data []byte) bool) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxEventSize)
+
+	var data bytes.Buffer
+	for scanner.Scan() {
+		line := scanner.Bytes()
+
+		// A blank line dispatches the event
+		if len(line) == 0 {
+			if data.Len() > 0 && !onData(data.Bytes()) {
+				return nil
+			}
+
+			data.Reset()
+			continue
+		}
+
+		if !bytes.HasPrefix(line, []byte("data:")) {
+			// comments, event names & ids aren't used by anything we talk to
+			continue
+		}
+
+		if data.Len() > 0 {
+			data.WriteByte('\n')
+		}
+		data.Write(bytes.TrimPrefix(bytes.TrimPrefix(line, []byte("data:")), []byte(" ")))
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	if data.Len() > 0 {
+		onData(data.Bytes())
+	}
+
+	return nil
+}
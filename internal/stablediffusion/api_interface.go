@@ -2,120 +2,101 @@ package stablediffusion
 
 import (
 	"bytes"
-	"encoding/json"
+	"context"
 	"errors"
-	"fmt"
-	"io/ioutil"
-	"net/http"
 
-	"github.com/M-Ro/aurora-ai/api"
-	"github.com/M-Ro/aurora-ai/config"
 	"github.com/M-Ro/aurora-ai/internal/gradio"
-	"github.com/gorilla/websocket"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 )
 
 type OnCompleteFunc func(images []bytes.Reader, err error)
 
-func Run(parameters *ParameterSet, onComplete OnCompleteFunc) error {
-	s := gradio.GetSession()
-	apiConn := gradio.NewAPIConnection()
-	host := viper.GetString("stable_diffusion.host")
+// Names of the webui functions we call, mapped to the app's endpoints by
+// stable_diffusion.endpoints.
+const (
+	EndpointTxt2Img = "txt2img"
+)
+
+func getEndpoints() (gradio.EndpointSet, error) {
+	endpoints := gradio.EndpointSet{}
+	err := viper.UnmarshalKey("stable_diffusion.endpoints", &endpoints)
+	if err != nil {
+		return nil, err
+	}
+
+	return endpoints, nil
+}
 
-	err := apiConn.Connect(host)
+// ResolveEndpoints looks up the fn indices of the configured endpoints on every webui.
+func ResolveEndpoints(ctx context.Context) error {
+	pool, err := gradio.GetPool("stable_diffusion")
 	if err != nil {
 		return err
 	}
 
-	err = runSocketHandler(apiConn, parameters, s, onComplete)
+	endpoints, err := getEndpoints()
+	if err != nil {
+		return err
+	}
 
-	return err
+	return pool.ResolveEndpoints(ctx, endpoints)
 }
 
-func runSocketHandler(
-	conn *gradio.APIConnection,
+// Run generates images from parameters. sessionKey identifies the conversation the
+// request was made from.
+// onQueue is called with our place in the queue while waiting, and may be nil.
+// Cancelling ctx abandons the job, returning ctx.Err() without calling onComplete.
+func Run(
+	ctx context.Context,
+	sessionKey string,
 	parameters *ParameterSet,
-	s *gradio.Session,
+	onQueue gradio.QueueUpdateFunc,
 	onComplete OnCompleteFunc,
 ) error {
-	sendQueue := make(chan string)
-	done := make(chan struct{})
-	go func() {
-		defer close(done)
-
-		for {
-			_, message, err := conn.Ws.ReadMessage()
-			if err != nil {
-				logrus.Error("ws read: ", err)
-				return
-			}
-
-			respPacket := SdResponsePacket{}
-			err = json.Unmarshal(message, &respPacket)
-			if err != nil {
-				logrus.Error("Failed to unmarshal response: ", err)
-				return
-			}
-
-			switch respPacket.Message {
-			case api.MsgSendHash:
-				onServerRequestHash(s, sendQueue)
-			case api.MsgSendData:
-				onServerRequestData(s, parameters, sendQueue)
-			case api.MsgProcessCompleted:
-				images, err := fetchImagesFromSd(respPacket)
-				onComplete(images, err)
-				return
-			case api.MsgProcessGenerating:
-				continue
-			case api.MsgEstimation:
-				continue
-			}
-		}
-	}()
-
-	for {
-		select {
-		case <-done:
-			return nil
-		case m := <-sendQueue:
-			logrus.Info("Send Message " + m)
-			err := conn.Ws.WriteMessage(websocket.TextMessage, []byte(m))
-			if err != nil {
-				logrus.Error("write:", err)
-				return nil
-			}
-		}
+	pool, err := gradio.GetPool("stable_diffusion")
+	if err != nil {
+		return err
 	}
-}
-
-func onServerRequestHash(session *gradio.Session, sendQueue chan string) {
-	bytes, err := json.Marshal(api.SendHashRequest{
-		SessionHash: session.SessionHash,
-		FnIndex:     config.FnDoTheThing,
-	})
 
+	endpoints, err := getEndpoints()
 	if err != nil {
-		logrus.Error("onServerRequestHash: Failed marshalling")
-		return
+		return err
 	}
 
-	sendQueue <- string(bytes)
-}
-func onServerRequestData(session *gradio.Session, parameters *ParameterSet, sendQueue chan string) {
-	bytes, err := json.Marshal(api.SendInferenceDataRequest{
-		SessionHash: session.SessionHash,
-		FnIndex:     config.FnDoTheThing,
-		Data:        parameters,
+	session := gradio.GetSession(sessionKey)
+
+	return pool.Run(func(backend *gradio.Backend) error {
+		client := gradio.NewClient(backend, session, endpoints)
+		return run(ctx, client, parameters, onQueue, onComplete)
 	})
+}
+
+func run(
+	ctx context.Context,
+	client *gradio.Client,
+	parameters *ParameterSet,
+	onQueue gradio.QueueUpdateFunc,
+	onComplete OnCompleteFunc,
+) error {
+	fnIndex, err := client.FnIndex(ctx, EndpointTxt2Img)
+	if err != nil {
+		return err
+	}
 
+	result, err := client.Call(ctx, fnIndex, parameters, onQueue)
 	if err != nil {
-		logrus.Error("onServerRequestData: Failed to marshal")
-		return
+		return err
+	}
+
+	images, err := fetchImagesFromSd(ctx, client, &result)
+	if ctx.Err() != nil {
+		return ctx.Err()
 	}
 
-	sendQueue <- string(bytes)
+	onComplete(images, err)
+
+	return nil
 }
 
 var (
@@ -126,23 +107,30 @@ var (
 	ErrFailedParsing       = errors.New("Failed parsing output data block")
 )
 
-func fetchImagesFromSd(response SdResponsePacket) ([]bytes.Reader, error) {
-	if !*response.Success {
+func fetchImagesFromSd(ctx context.Context, client *gradio.Client, result *gradio.Event) ([]bytes.Reader, error) {
+	if !result.Success {
 		return []bytes.Reader{}, ErrFailureOnGeneration
 	}
 
-	if response.Output == nil {
-		return []bytes.Reader{}, ErrNoOutput
+	if !result.HasOutput() {
+		return []bytes.Reader{}, &gradio.ProtocolError{Reason: "txt2img", Err: ErrNoOutput}
 	}
 
-	if len(response.Output.Data.Images) == 0 {
+	output := SdResponseOutput{}
+	err := result.Decode(&output)
+	if err != nil {
+		logrus.Error("Failed parsing txt2img output: ", err)
+		return []bytes.Reader{}, &gradio.ProtocolError{Reason: "txt2img", Err: ErrFailedParsing}
+	}
+
+	if len(output.Data.Images) == 0 {
 		return []bytes.Reader{}, ErrNoImage
 	}
 
 	// Fetch the images into buffers and attach readers to return
 	imageReaders := []bytes.Reader{}
-	for _, imageBlock := range response.Output.Data.Images {
-		imageReader, err := downloadImageAsReader(imageBlock.Filename)
+	for _, imageBlock := range output.Data.Images {
+		imageReader, err := downloadImageAsReader(ctx, client, imageBlock.Filename)
 		if err != nil {
 			return []bytes.Reader{}, ErrFetchImages
 		}
@@ -153,29 +141,11 @@ func fetchImagesFromSd(response SdResponsePacket) ([]bytes.Reader, error) {
 	return imageReaders, nil
 }
 
-var (
-	ErrDownloadFailed = errors.New("Failed to GET file")
-	ErrReadFailed     = errors.New("Failed to read response bytes")
-)
-
-func downloadImageAsReader(filepath string) (*bytes.Reader, error) {
-	url := fmt.Sprintf(
-		"http://%s/file=%s",
-		viper.GetString("stable_diffusion.host"),
-		filepath,
-	)
-
-	res, err := http.Get(url)
+func downloadImageAsReader(ctx context.Context, client *gradio.Client, filepath string) (*bytes.Reader, error) {
+	data, err := client.FetchFile(ctx, filepath)
 	if err != nil {
-		return nil, ErrDownloadFailed
+		return nil, err
 	}
 
-	data, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		return nil, ErrReadFailed
-	}
-
-	res.Body.Close()
-
 	return bytes.NewReader(data), nil
 }
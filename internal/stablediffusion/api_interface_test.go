@@ -0,0 +1,62 @@
+package stablediffusion
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/M-Ro/aurora-ai/internal/gradio"
+	"github.com/M-Ro/aurora-ai/internal/gradio/fake"
+	"github.com/spf13/viper"
+)
+
+func TestRun(t *testing.T) {
+	for _, version := range []string{"3.28.0", "4.0.0", "4.8.0", "4.16.0", "4.36.0"} {
+		t.Run(version, func(t *testing.T) {
+			server := fake.NewDemoServer(version, 0)
+			httpServer := httptest.NewServer(server.Handler())
+			defer httpServer.Close()
+
+			viper.Set("stable_diffusion.host", httpServer.Listener.Addr().String())
+			viper.Set("stable_diffusion.endpoints", map[string]interface{}{
+				"txt2img": map[string]interface{}{"elem_id": "txt2img_generate"},
+			})
+
+			params := NewParameterSet()
+			params.PositivePrompt = "a lighthouse"
+
+			estimations := 0
+			var images []bytes.Reader
+			err := Run(
+				context.Background(),
+				t.Name(),
+				&params,
+				func(gradio.Estimation) { estimations++ },
+				func(result []bytes.Reader, err error) {
+					if err != nil {
+						t.Error(err)
+					}
+					images = result
+				},
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if estimations != 2 {
+				t.Errorf("got %d estimations, want 2", estimations)
+			}
+
+			if len(images) != 1 {
+				t.Fatalf("got %d images, want 1", len(images))
+			}
+
+			data, _ := ioutil.ReadAll(&images[0])
+			if !bytes.Equal(data, server.Files["demo.png"]) {
+				t.Error("downloaded image doesn't match the one served")
+			}
+		})
+	}
+}
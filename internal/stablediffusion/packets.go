@@ -6,7 +6,6 @@ import (
 	"math/rand"
 	"reflect"
 
-	"github.com/M-Ro/aurora-ai/api"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 )
@@ -136,12 +135,6 @@ func NewParameterSet() ParameterSet {
 	}
 }
 
-type SdResponsePacket struct {
-	Message api.GradioResponseMessage `json:"msg"`
-	Output  *SdResponseOutput         `json:"output"`
-	Success *bool                     `json:"success"`
-}
-
 type SdResponseOutput struct {
 	Data            DataBlock `json:"data"`
 	IsGenerating    bool      `json:"is_generating"`
@@ -169,13 +162,28 @@ func (i *DataBlock) UnmarshalJSON(data []byte) error {
 
 	imageBlocks := []ImageBlock{}
 
+	if len(v) == 0 {
+		i.Images = imageBlocks
+		return nil
+	}
+
 	imageMaps, _ := v[0].([]interface{})
 	for _, imgMap := range imageMaps {
-		v := imgMap.(map[string]interface{})
+		v, ok := imgMap.(map[string]interface{})
+		if !ok {
+			return ErrFailedParsing
+		}
+
+		filename, ok := v["name"].(string)
+		if !ok {
+			return ErrFailedParsing
+		}
+
+		isFile, _ := v["is_file"].(bool)
 
 		imageBlocks = append(imageBlocks, ImageBlock{
-			Filename: v["name"].(string),
-			IsFile:   v["is_file"].(bool),
+			Filename: filename,
+			IsFile:   isFile,
 			Data:     nil, // Does not appear to be used by the API
 		})
 	}
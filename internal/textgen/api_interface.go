@@ -1,250 +1,220 @@
 package textgen
 
 import (
-	"encoding/json"
-	"fmt"
+	"context"
 	"strings"
 
 	"github.com/M-Ro/aurora-ai/api"
-	"github.com/M-Ro/aurora-ai/config"
-	"github.com/M-Ro/aurora-ai/internal/helpers"
-	"github.com/gorilla/websocket"
+	"github.com/M-Ro/aurora-ai/internal/gradio"
+	"github.com/M-Ro/aurora-ai/internal/textgen/character"
+	"github.com/M-Ro/aurora-ai/internal/textgen/prompt"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 )
 
-type InferenceUpdateFunc func(string)
-type InferenceCompleteFunc func()
-
-type PacketMode int
+// Kinds of backend, selected by <key>.backend.
+const (
+	// BackendGradio drives text-generation-webui's Gradio UI
+	BackendGradio = "gradio"
+	// BackendWebuiApi uses text-generation-webui's api extension, configured by <key>.api
+	BackendWebuiApi = "webui_api"
+	// BackendOpenAI uses an OpenAI-compatible chat completions api, configured by <key>.openai
+	BackendOpenAI = "openai"
+	// BackendFake answers every job with a canned reply, without any server
+	BackendFake = "fake"
+)
 
+// Names of the webui functions we call, mapped to the app's endpoints by <key>.endpoints.
 const (
-	Prepare PacketMode = iota
-    Inference	
+	EndpointParameters = "parameters"
+	EndpointGenerate   = "generate"
 )
 
-func RunInference(
-	query string,
-	onUpdate InferenceUpdateFunc,
-	onComplete InferenceCompleteFunc,
-) error {
-	s := GetSession()
-	apiConn := newAPIConnection()
+// GradioGenerator drives text-generation-webui through its Gradio UI, the hosts of
+// which are pooled under Key.
+type GradioGenerator struct {
+	Key string
+}
 
-	// First we need to open the connection to send the prep statement
-	err := apiConn.connect()
+func (g *GradioGenerator) getEndpoints() (gradio.EndpointSet, error) {
+	endpoints := gradio.EndpointSet{}
+	err := viper.UnmarshalKey(g.Key+".endpoints", &endpoints)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	mode := Prepare
-	err = runSocketHandler(apiConn, query, s, mode, onUpdate, onComplete)
-    if err != nil {
-        logrus.Error("error: ", err)
-        return err
-    }
+	return endpoints, nil
+}
 
-	// The first connection will be terminated by the server, run again to send the actual
-	// inference statement
-	err = apiConn.connect()
+// ResolveEndpoints looks up the fn indices of the configured endpoints on every webui.
+func (g *GradioGenerator) ResolveEndpoints(ctx context.Context) error {
+	pool, err := gradio.GetPool(g.Key)
 	if err != nil {
 		return err
 	}
 
-	mode = Inference 
-	err = runSocketHandler(apiConn, query, s, mode, onUpdate, onComplete)
+	endpoints, err := g.getEndpoints()
+	if err != nil {
+		return err
+	}
+
+	return pool.ResolveEndpoints(ctx, endpoints)
+}
+
+// Generate runs the job on the webui, each conversation keeping its own session there.
+func (g *GradioGenerator) Generate(ctx context.Context, job *Job) (Usage, error) {
+	pool, err := gradio.GetPool(g.Key)
+	if err != nil {
+		return Usage{}, err
+	}
+
+	endpoints, err := g.getEndpoints()
+	if err != nil {
+		return Usage{}, err
+	}
+
+	format, err := prompt.ForKey(g.Key)
+	if err != nil {
+		return Usage{}, err
+	}
+
+	session := gradio.GetSession(job.SessionKey)
+	text := job.Context.PromptAs(format)
+
+	output := ""
+	onComplete := func(reply string) {
+		output = reply
+		job.OnComplete(reply)
+	}
 
-	return err
+	err = pool.Run(func(backend *gradio.Backend) error {
+		client := gradio.NewClient(backend, session, endpoints)
+		return runInference(ctx, client, text, job.OnQueue, job.OnUpdate, onComplete)
+	})
+	if err != nil {
+		return Usage{}, err
+	}
+
+	return estimateUsage(job.Context, output), nil
 }
 
-func runSocketHandler(
-	conn *APIConnection,
-	query string,
-	s *Session,
-	mode PacketMode,
+func runInference(
+	ctx context.Context,
+	client *gradio.Client,
+	prompt string,
+	onQueue gradio.QueueUpdateFunc,
 	onUpdate InferenceUpdateFunc,
 	onComplete InferenceCompleteFunc,
 ) error {
+	paramsFn, err := client.FnIndex(ctx, EndpointParameters)
+	if err != nil {
+		return err
+	}
 
-	sendQueue := make(chan string)
-	done := make(chan struct{})
-	go func() {
-		defer close(done)
+	generateFn, err := client.FnIndex(ctx, EndpointGenerate)
+	if err != nil {
+		return err
+	}
 
-		for {
-            logrus.Info("Calling ReadMessage() to block")
-			_, message, err := conn.Ws.ReadMessage()
-			if err != nil {
-				logrus.Error("ws read: ", err)
-				return
-			}
+	params, err := getParameters()
+	if err != nil {
+		return err
+	}
 
-			logrus.Info("ws recv: ", string(message))
+	// The generation parameters are set by their own job, which the server terminates
+	// once done, so it has to complete before the actual inference job is submitted.
+	_, err = client.Call(ctx, paramsFn, params, onQueue)
+	if err != nil {
+		logrus.Error("error: ", err)
+		return err
+	}
 
-			respPacket := api.GradioResponsePacket{}
-			err = json.Unmarshal(message, &respPacket)
-			if err != nil {
-				logrus.Error("Failed to unmarshal response: ", err)
-				return
-			}
+	events, err := client.Submit(ctx, generateFn, []*string{&prompt, nil})
+	if err != nil {
+		return err
+	}
 
-			switch respPacket.Message {
-			case api.MsgSendHash:
-				onServerRequestHash(s, mode, sendQueue)
-			case api.MsgSendData:
-				onServerRequestData(s, mode, query, sendQueue)
-			case api.MsgProcessCompleted:
-				onServerProcessComplete(s, &mode, sendQueue)
-				onComplete()
-				return
-			case api.MsgProcessGenerating:
-				dataStr, err := onServerProcessGenerating(&respPacket)
-				if err != nil {
-					logrus.Error("Failed handling ProcessGenerating packet")
-					return
-				}
-				onUpdate(dataStr)
-            case api.MsgEstimation:
-                continue
-			}
+	output := ""
+	for ev := range events {
+		if ev.Err != nil {
+			return ev.Err
 		}
-	}()
-
-	for {
-		select {
-		case <-done:
-			return nil
-		case m := <-sendQueue:
-			logrus.Info("Send Message " + m)
-			err := conn.Ws.WriteMessage(websocket.TextMessage, []byte(m))
+
+		switch ev.Message {
+		case api.MsgEstimation:
+			if onQueue != nil {
+				onQueue(*ev.Estimation)
+			}
+		case api.MsgProcessGenerating:
+			output, err = onServerProcessGenerating(&ev, prompt)
 			if err != nil {
-				logrus.Error("write:", err)
-				return nil
+				logrus.Error("Failed handling ProcessGenerating packet")
+				return err
 			}
+			onUpdate(output)
+		case api.MsgProcessCompleted:
+			onComplete(output)
 		}
 	}
-}
 
-func onServerRequestHash(session *Session, mode PacketMode, sendQueue chan string) {
-	logrus.Info("Sending auth hash: ", session.SessionHash)
-
-	fnIndex, err := getFnIndex(mode)
-	bytes, err := json.Marshal(api.SendHashRequest{
-		SessionHash: session.SessionHash,
-		FnIndex:     fnIndex,
-	})
+	return ctx.Err()
+}
 
+func onServerProcessGenerating(ev *gradio.Event, prompt string) (string, error) {
+	output := api.GradioResponseOutput{}
+	err := ev.Decode(&output)
 	if err != nil {
-        logrus.Error("onServerRequestHash: Failed marshalling")
-		return
+		return "", &gradio.ProtocolError{Reason: "malformed generation output", Err: err}
 	}
 
-	sendQueue <- string(bytes)
-}
+	if len(output.Data) == 0 {
+		return "", &gradio.ProtocolError{Reason: "generation output has no data"}
+	}
 
-func onServerRequestData(session *Session, mode PacketMode, query string, sendQueue chan string) {
-	logrus.Info("Sending data")
-
-	fnIndex, err := getFnIndex(mode)
-    if err != nil {
-        logrus.Error("well shit, err isnt nil", err)
-    }
-
-    // this is stupid, do a custom marshaller for this bullshit later
-    if mode == Prepare {
-        sillyString := fmt.Sprintf(
-            "{\"fn_index\":8, \"data\":[1512,-1,1.99,0.18,30,1,1.15,1,0,0,true,0,1,1,false,true,\"\\\"\\\\n### Human:\\\", \\\"\\\\n### Assistant:\\\"\"],\"event_data\":null,\"session_hash\":\"%s\"}",
-            session.SessionHash,
-        )
-
-        sendQueue <- sillyString
-    } else if mode == Inference {
-        bytes, err := json.Marshal(api.SendInferenceDataRequest{
-            SessionHash: session.SessionHash,
-            FnIndex:     fnIndex,
-            Data:        getData(mode, &query),
-        })
-
-        if err != nil {
-            logrus.Error("onServerRequestData: Failed to marshal")
-            return
-        }
-
-	    sendQueue <- string(bytes)
-    }
+	return getBotStringFromResponse(output.Data[0], prompt), nil
 }
 
-func onServerProcessComplete(session *Session, mode *PacketMode, sendQueue chan string) {
-	if *mode == Prepare {
-		*mode = Inference 
-		return
+// getBotStringFromResponse isolates the bot's reply from the output, which the webui
+// returns with the prompt in front. The reply runs on until cut by the stop strings.
+func getBotStringFromResponse(response string, prompt string) string {
+	if strings.HasPrefix(response, prompt) {
+		return response[len(prompt):]
 	}
 
-}
-
-func onServerProcessGenerating(packet *api.GradioResponsePacket) (string, error) {
-    return getBotStringFromResponse(packet.Output.Data[0])
-}
+	// The webui may have reformatted the prompt, so fall back to the last bot token
+	// it holds, which is where our prompt left off
+	botToken := character.BotToken()
+	lB := strings.LastIndex(response, botToken)
+	if lB < 0 {
+		return response
+	}
 
-// getBotStringFromResponse isolates & extracts the actual bot response from the output
-func getBotStringFromResponse(response string) (string, error) {
-    // Extract the actual response
-    botToken := viper.GetString("llm.identifier_b")
-
-    // We need to remove the last string from the human token
-    // why? because the api is inconsistent and requires a suffix colon to inference without
-    // going schizo, but at termination doesn't bother to produce a suffix colon itself.
-    humanToken := viper.GetString("llm.identifier_p")
-    humanToken = strings.TrimRight(humanToken, ":")
-
-    lB := strings.LastIndex(response, botToken)
-    lH := strings.LastIndex(response, humanToken)
-
-    // If lH > lB, the bot has re-prompted the user, so fetch the string upto that point
-    if lH > lB {
-        a := helpers.Substr(
-            response,
-            lB + len(botToken),
-            lH - (lB + len(botToken)),
-        )
-
-        return a, nil
-    }
-
-    return helpers.Substr(
-        response,
-        lB + len(botToken),
-        len(response) - (lB + len(botToken)),
-    ), nil
+	return response[lB+len(botToken):]
 }
 
-func getFnIndex(mode PacketMode) (uint32, error) {
-	switch mode {
-	case Prepare:
-		return config.FnSendNoFuckingIdea8, nil
-	case Inference:
-		return config.FnSendNoFuckingIdea9, nil
+// getParameters returns the generation parameters from llm.settings. Settings left out
+// of the config keep the webui's defaults.
+func getParameters() (*api.ParameterSet, error) {
+	params := api.ParameterSet{
+		MaxNewTokens:             200,
+		Seed:                     -1,
+		Temperature:              0.7,
+		TopP:                     0.9,
+		TopK:                     20,
+		TypicalP:                 1,
+		RepetitionPenalty:        1.15,
+		EncoderRepetitionPenalty: 1,
+		DoSample:                 true,
+		NumBeams:                 1,
+		LengthPenalty:            1,
+		AddBosToken:              true,
+		MaximumPromptTokens:      2048,
 	}
 
-	//FIXME err
-	return 0, nil
-}
-
-func getData(mode PacketMode, query *string) []*string {
-	switch mode {
-	//case Prepare:
-		//return []*string{query}
-	case Inference:
-        context := viper.GetString("llm.context")
-        botToken := viper.GetString("llm.identifier_b")
-        humanToken := viper.GetString("llm.identifier_p")
-
-        output := fmt.Sprintf("%s\n%s \n%s\n%s", context, humanToken, *query, botToken) 
-		return []*string{
-            &output,
-			nil,
-		}
-	default:
-		return []*string{}
+	err := viper.UnmarshalKey("llm.settings", &params)
+	if err != nil {
+		return nil, err
 	}
+
+	return &params, nil
 }
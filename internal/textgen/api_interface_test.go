@@ -0,0 +1,310 @@
+package textgen
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/M-Ro/aurora-ai/internal/gradio"
+	"github.com/M-Ro/aurora-ai/internal/gradio/fake"
+	chat "github.com/M-Ro/aurora-ai/internal/textgen/context"
+	"github.com/spf13/viper"
+)
+
+const testReply = " Hi there, how can I help?"
+
+// gradioVersions speak each queue protocol: websockets, sse, sse_v1, sse_v2 & sse_v3.
+var gradioVersions = []string{"3.28.0", "4.0.0", "4.8.0", "4.16.0", "4.36.0"}
+
+// startFakeWebui serves a fake webui whose generate function plays the script returned
+// by generate, and points the llm config at it.
+func startFakeWebui(t *testing.T, version string, generate func(prompt string) fake.Script) *fake.Server {
+	server := fake.NewServer(version, []fake.Function{
+		{ApiName: "parameters"},
+		{
+			ApiName: "generate",
+			Run: func(data json.RawMessage) fake.Script {
+				inputs := []interface{}{}
+				json.Unmarshal(data, &inputs)
+				prompt, _ := inputs[0].(string)
+
+				return generate(prompt)
+			},
+		},
+	})
+
+	httpServer := httptest.NewServer(server.Handler())
+	t.Cleanup(httpServer.Close)
+
+	viper.Set("llm.host", httpServer.Listener.Addr().String())
+	viper.Set("llm.identifier_p", "### Human:")
+	viper.Set("llm.identifier_b", "### Assistant:")
+	viper.Set("llm.settings.maximum_prompt_tokens", 2048)
+	viper.Set("llm.endpoints", map[string]interface{}{
+		"parameters": map[string]interface{}{"api_name": "parameters"},
+		"generate":   map[string]interface{}{"api_name": "generate"},
+	})
+
+	return server
+}
+
+// conversation returns a conversation of a single message from a user.
+func conversation(query string) *chat.ChatContext {
+	return &chat.ChatContext{
+		Messages: []chat.ContextMessage{
+			{Author: chat.Author{Id: "1", Name: "user"}, Message: query},
+		},
+	}
+}
+
+// streamReply streams testReply after the prompt a word at a time.
+func streamReply(prompt string) fake.Script {
+	script := fake.Script{
+		Estimations: []fake.Estimation{{Rank: 2, QueueSize: 3, RankEta: 4.5}},
+	}
+
+	text := prompt
+	for _, word := range strings.SplitAfter(testReply, " ") {
+		text += word
+		script.Chunks = append(script.Chunks, []interface{}{text})
+	}
+	script.Output = []interface{}{text}
+
+	return script
+}
+
+func TestRunInference(t *testing.T) {
+	for _, version := range gradioVersions {
+		t.Run(version, func(t *testing.T) {
+			server := startFakeWebui(t, version, streamReply)
+
+			estimations := []gradio.Estimation{}
+			updates := []string{}
+			completed := ""
+
+			err := RunInference(
+				context.Background(),
+				t.Name(),
+				conversation("hello"),
+				func(est gradio.Estimation) { estimations = append(estimations, est) },
+				func(output string) { updates = append(updates, output) },
+				func(output string) { completed = output },
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if completed != testReply {
+				t.Errorf("completed with %q, want %q", completed, testReply)
+			}
+
+			if len(updates) != len(strings.SplitAfter(testReply, " ")) {
+				t.Errorf("got %d updates: %q", len(updates), updates)
+			}
+
+			if len(estimations) != 1 || estimations[0].Rank != 2 || estimations[0].RankEta != 4500*time.Millisecond {
+				t.Errorf("unexpected estimations %+v", estimations)
+			}
+
+			requests := server.Requests()
+			if len(requests) != 2 || requests[0].FnIndex != 0 || requests[1].FnIndex != 1 {
+				t.Fatalf("unexpected requests %+v", requests)
+			}
+
+			if !strings.Contains(string(requests[1].Data), "hello") {
+				t.Errorf("prompt missing from %s", requests[1].Data)
+			}
+		})
+	}
+}
+
+func TestRunInferenceFailures(t *testing.T) {
+	cases := []struct {
+		name   string
+		script fake.Script
+		check  func(err error) bool
+	}{
+		{
+			name:   "upstream exception",
+			script: fake.Script{Error: "CUDA out of memory"},
+			check: func(err error) bool {
+				var upstream *gradio.UpstreamError
+				return errors.As(err, &upstream) && upstream.Message == "CUDA out of memory"
+			},
+		},
+		{
+			name:   "queue full",
+			script: fake.Script{QueueFull: true},
+			check:  func(err error) bool { return errors.Is(err, gradio.ErrQueueFull) },
+		},
+		{
+			name:   "disconnect",
+			script: fake.Script{Chunks: [][]interface{}{{"partial"}}, Disconnect: true},
+			check:  func(err error) bool { return errors.Is(err, gradio.ErrConnectionLost) },
+		},
+		{
+			name:   "malformed output",
+			script: fake.Script{Chunks: [][]interface{}{{}}},
+			check: func(err error) bool {
+				var protocol *gradio.ProtocolError
+				return errors.As(err, &protocol)
+			},
+		},
+	}
+
+	for _, version := range gradioVersions {
+		for _, c := range cases {
+			c := c
+			t.Run(version+"/"+c.name, func(t *testing.T) {
+				startFakeWebui(t, version, func(string) fake.Script { return c.script })
+
+				err := RunInference(context.Background(), t.Name(), conversation("hello"), nil, func(string) {}, func(string) {})
+				if !c.check(err) {
+					t.Errorf("unexpected error %v", err)
+				}
+			})
+		}
+	}
+}
+
+func TestRunInferenceCancelled(t *testing.T) {
+	for _, version := range gradioVersions {
+		t.Run(version, func(t *testing.T) {
+			startFakeWebui(t, version, func(prompt string) fake.Script {
+				script := streamReply(prompt)
+				script.Interval = time.Second
+				return script
+			})
+
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancel()
+
+			err := RunInference(ctx, t.Name(), conversation("hello"), nil, func(string) {}, func(string) {})
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("expected deadline exceeded, got %v", err)
+			}
+		})
+	}
+}
+
+func TestRunInferenceSameSession(t *testing.T) {
+	for _, version := range gradioVersions {
+		t.Run(version, func(t *testing.T) {
+			startFakeWebui(t, version, streamReply)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			// Two replies in one channel at once share its session
+			errs := make(chan error)
+			completed := make([]string, 2)
+			for n := range completed {
+				go func(n int) {
+					errs <- RunInference(ctx, t.Name(), conversation("hello"), nil, func(string) {}, func(output string) {
+						completed[n] = output
+					})
+				}(n)
+			}
+
+			for range completed {
+				if err := <-errs; err != nil {
+					t.Error(err)
+				}
+			}
+
+			for n, output := range completed {
+				if output != testReply {
+					t.Errorf("job %d completed with %q, want %q", n, output, testReply)
+				}
+			}
+		})
+	}
+}
+
+func TestRunInferenceUnsupportedProtocol(t *testing.T) {
+	server := startFakeWebui(t, "6.0.0", streamReply)
+	server.Protocol = "sse_v9"
+
+	err := RunInference(context.Background(), t.Name(), conversation("hello"), nil, func(string) {}, func(string) {})
+
+	var unsupported *gradio.UnsupportedProtocolError
+	if !errors.As(err, &unsupported) || unsupported.Name != "sse_v9" {
+		t.Errorf("expected unsupported protocol, got %v", err)
+	}
+}
+
+func TestRunInferenceCancelledHungReset(t *testing.T) {
+	server := fake.NewServer("4.36.0", []fake.Function{
+		{ApiName: "parameters"},
+		{
+			ApiName: "generate",
+			Run: func(data json.RawMessage) fake.Script {
+				script := streamReply("")
+				script.Interval = time.Second
+				return script
+			},
+		},
+	})
+
+	// The app never answers being told to drop the job
+	hung := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.Handle("/", server.Handler())
+	mux.HandleFunc("/reset", func(w http.ResponseWriter, r *http.Request) {
+		<-hung
+	})
+
+	httpServer := httptest.NewServer(mux)
+	t.Cleanup(httpServer.Close)
+	t.Cleanup(func() { close(hung) })
+
+	startFakeWebui(t, "4.36.0", streamReply)
+	viper.Set("llm.host", httpServer.Listener.Addr().String())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := RunInference(ctx, t.Name(), conversation("hello"), nil, func(string) {}, func(string) {})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+
+	if time.Since(start) > 2*time.Second {
+		t.Errorf("cancelling waited %s on the app", time.Since(start))
+	}
+}
+
+func TestParameterData(t *testing.T) {
+	viper.Set("llm.settings", map[string]interface{}{
+		"max_new_tokens":        1512,
+		"maximum_prompt_tokens": 2048,
+		"temperature":           1.99,
+		"top_p":                 0.18,
+		"top_k":                 30,
+		"typical_p":             1,
+		"repetition_penalty":    1.15,
+		"stopping_strings":      []string{"\n### Human:", "\n### Assistant:"},
+	})
+	defer viper.Set("llm.settings", nil)
+
+	params, err := getParameters()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := json.Marshal(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `[1512,-1,1.99,0.18,30,1,1.15,1,0,0,true,0,1,1,false,true,"\"\\n### Human:\", \"\\n### Assistant:\""]`
+	if string(data) != want {
+		t.Errorf("got %s\nwant %s", data, want)
+	}
+}
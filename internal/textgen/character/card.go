@@ -0,0 +1,130 @@
+// Package character loads SillyTavern/TavernAI character cards to give the bot its persona.
+package character
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"hash/crc32"
+	"io/ioutil"
+	"strings"
+)
+
+var (
+	ErrNotACard         = errors.New("Not a character card")
+	ErrNoCharacterChunk = errors.New("PNG has no chara chunk")
+	ErrMalformedPNG     = errors.New("Malformed PNG")
+)
+
+// Card is a character, as read from a V1 or V2 card.
+type Card struct {
+	Name            string `json:"name"`
+	Description     string `json:"description"`
+	Personality     string `json:"personality"`
+	Scenario        string `json:"scenario"`
+	FirstMessage    string `json:"first_mes"`
+	ExampleDialogue string `json:"mes_example"`
+	// SystemPrompt is only found in V2 cards
+	SystemPrompt string `json:"system_prompt"`
+}
+
+// cardV2 wraps the card's fields in data, see
+// https://github.com/malfoyslastname/character-card-spec-v2
+type cardV2 struct {
+	Spec string `json:"spec"`
+	Data Card   `json:"data"`
+}
+
+const specV2 = "chara_card_v2"
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// LoadCard loads a card from a .json file or a .png with the card embedded.
+func LoadCard(path string) (*Card, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseCard(data)
+}
+
+// ParseCard parses a card from its JSON, or from a PNG with the card embedded.
+func ParseCard(data []byte) (*Card, error) {
+	if bytes.HasPrefix(data, pngSignature) {
+		encoded, err := pngText(data, "chara")
+		if err != nil {
+			return nil, err
+		}
+
+		data, err = base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	v2 := cardV2{}
+	err := json.Unmarshal(data, &v2)
+	if err != nil {
+		return nil, err
+	}
+
+	if v2.Spec == specV2 {
+		return validate(&v2.Data)
+	}
+
+	// V1 cards hold the fields at the top level
+	card := Card{}
+	err = json.Unmarshal(data, &card)
+	if err != nil {
+		return nil, err
+	}
+
+	return validate(&card)
+}
+
+func validate(card *Card) (*Card, error) {
+	if strings.TrimSpace(card.Name) == "" {
+		return nil, ErrNotACard
+	}
+
+	return card, nil
+}
+
+// pngText returns the text of the PNG's tEXt chunk with the given keyword.
+func pngText(data []byte, keyword string) (string, error) {
+	data = data[len(pngSignature):]
+
+	for len(data) >= 12 {
+		length := binary.BigEndian.Uint32(data)
+		if uint64(length)+12 > uint64(len(data)) {
+			return "", ErrMalformedPNG
+		}
+
+		kind := string(data[4:8])
+		body := data[8 : 8+length]
+		crc := binary.BigEndian.Uint32(data[8+length:])
+		data = data[12+length:]
+
+		if crc != crc32.ChecksumIEEE(append([]byte(kind), body...)) {
+			return "", ErrMalformedPNG
+		}
+
+		if kind == "IEND" {
+			break
+		}
+
+		if kind != "tEXt" {
+			continue
+		}
+
+		parts := bytes.SplitN(body, []byte{0}, 2)
+		if len(parts) == 2 && string(parts[0]) == keyword {
+			return string(parts[1]), nil
+		}
+	}
+
+	return "", ErrNoCharacterChunk
+}
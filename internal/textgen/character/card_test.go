@@ -0,0 +1,124 @@
+package character
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+const testCardV1 = `{
+	"name": "Aqua",
+	"description": "{{char}} is a water goddess.",
+	"personality": "cheerful",
+	"scenario": "{{user}} meets {{char}} at the guild.",
+	"first_mes": "Hi {{user}}!",
+	"mes_example": "<START>\n{{user}}: who are you?\n{{char}}: A goddess!"
+}`
+
+const testCardV2 = `{
+	"spec": "chara_card_v2",
+	"spec_version": "2.0",
+	"data": {"name": "Megumin", "description": "An archwizard.", "first_mes": "Explosion!"}
+}`
+
+// pngWithText returns a 1x1 PNG carrying a tEXt chunk.
+func pngWithText(t *testing.T, keyword string, text string) []byte {
+	t.Helper()
+
+	buf := bytes.Buffer{}
+	err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 1, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := append([]byte(keyword+"\x00"), text...)
+	chunk := make([]byte, 4, 12+len(body))
+	binary.BigEndian.PutUint32(chunk, uint32(len(body)))
+	chunk = append(chunk, "tEXt"...)
+	chunk = append(chunk, body...)
+	crc := make([]byte, 4)
+	binary.BigEndian.PutUint32(crc, crc32.ChecksumIEEE(chunk[4:]))
+	chunk = append(chunk, crc...)
+
+	// Insert the chunk before IEND, the last 12 bytes
+	data := buf.Bytes()
+	end := len(data) - 12
+
+	return append(append(append([]byte{}, data[:end]...), chunk...), data[end:]...)
+}
+
+func TestParseCard(t *testing.T) {
+	cases := map[string][]byte{
+		"Aqua":    []byte(testCardV1),
+		"Megumin": []byte(testCardV2),
+		"Aqua (png)": pngWithText(t, "chara",
+			base64.StdEncoding.EncodeToString([]byte(testCardV1))),
+	}
+
+	for name, data := range cases {
+		card, err := ParseCard(data)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+
+		if card.Name != name && card.Name+" (png)" != name {
+			t.Errorf("%s: got name %q", name, card.Name)
+		}
+	}
+
+	_, err := ParseCard(pngWithText(t, "Comment", "nothing here"))
+	if err != ErrNoCharacterChunk {
+		t.Errorf("Expected ErrNoCharacterChunk, got %v", err)
+	}
+
+	_, err = ParseCard([]byte(`{"description": "no name"}`))
+	if err != ErrNotACard {
+		t.Errorf("Expected ErrNotACard, got %v", err)
+	}
+}
+
+func TestPersona(t *testing.T) {
+	dir := t.TempDir()
+	err := ioutil.WriteFile(filepath.Join(dir, "aqua.json"), []byte(testCardV1), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	viper.Set("llm.context", "A chat.")
+	viper.Set("llm.identifier_b", "### Assistant:")
+	viper.Set("llm.character.dir", dir)
+	viper.Set("llm.character.user", "Kazuma")
+	viper.Set("llm.character.name", "")
+	defer viper.Set("llm.character.name", "")
+
+	if Persona() != "A chat." || BotToken() != "### Assistant:" || Greeting() != "" {
+		t.Errorf("Without a card expected the configured persona, got %q, %q", Persona(), BotToken())
+	}
+
+	viper.Set("llm.character.name", "aqua")
+
+	want := "Aqua is a water goddess.\n\n" +
+		"Aqua's personality: cheerful\n\n" +
+		"Scenario: Kazuma meets Aqua at the guild.\n\n" +
+		"Example dialogue:\nKazuma: who are you?\nAqua: A goddess!"
+	if got := Persona(); got != want {
+		t.Errorf("Got persona %q, want %q", got, want)
+	}
+
+	if BotToken() != "Aqua:" {
+		t.Errorf("Got bot token %q", BotToken())
+	}
+
+	if Greeting() != "Hi Kazuma!" {
+		t.Errorf("Got greeting %q", Greeting())
+	}
+}
@@ -0,0 +1,172 @@
+package character
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// Settings are read from llm.character.
+type Settings struct {
+	// Dir holds the cards, as .json or .png files
+	Dir string `mapstructure:"dir"`
+	// Name picks the card by its character's name or file name. Without one the persona
+	// is llm.context
+	Name string `mapstructure:"name"`
+	// User stands in for {{user}} in the card, defaulting to llm.identifier_p
+	User string `mapstructure:"user"`
+}
+
+var (
+	mu        sync.Mutex
+	current   *Card
+	signature string
+)
+
+// LoadDir loads every card in dir, keyed by lowercased character & file name. Files which
+// aren't cards are skipped.
+func LoadDir(dir string) (map[string]*Card, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	cards := map[string]*Card{}
+	for _, file := range files {
+		ext := strings.ToLower(filepath.Ext(file.Name()))
+		if file.IsDir() || (ext != ".json" && ext != ".png") {
+			continue
+		}
+
+		card, err := LoadCard(filepath.Join(dir, file.Name()))
+		if err != nil {
+			logrus.Warnf("Skipping character card %s: %v", file.Name(), err)
+			continue
+		}
+
+		cards[strings.ToLower(strings.TrimSuffix(file.Name(), filepath.Ext(file.Name())))] = card
+		cards[strings.ToLower(card.Name)] = card
+	}
+
+	return cards, nil
+}
+
+// Current returns the card picked by llm.character, or nil if there is none.
+func Current() *Card {
+	settings := Settings{}
+	err := viper.UnmarshalKey("llm.character", &settings)
+	if err != nil {
+		logrus.Error(err)
+		return nil
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	s := settings.Dir + " " + settings.Name
+	if s == signature {
+		return current
+	}
+
+	signature = s
+	current = nil
+
+	if settings.Name == "" {
+		return nil
+	}
+
+	cards, err := LoadDir(settings.Dir)
+	if err != nil {
+		logrus.Error("Loading character cards failed: ", err)
+		return nil
+	}
+
+	card, ok := cards[strings.ToLower(settings.Name)]
+	if !ok {
+		logrus.Errorf("No character card for %q in %s", settings.Name, settings.Dir)
+		return nil
+	}
+
+	logrus.Infof("Using the persona of %s", card.Name)
+	current = card
+
+	return current
+}
+
+// BotToken returns the bot's speaker token, the card's character name if there is one.
+func BotToken() string {
+	card := Current()
+	if card == nil {
+		return viper.GetString("llm.identifier_b")
+	}
+
+	return card.Name + ":"
+}
+
+// Persona returns what the prompt opens with, describing who the bot is: the card's
+// description, personality, scenario & example dialogue, or else llm.context.
+func Persona() string {
+	card := Current()
+	if card == nil {
+		return viper.GetString("llm.context")
+	}
+
+	parts := []string{}
+	for _, part := range []struct{ format, text string }{
+		{"%s", card.SystemPrompt},
+		{"%s", card.Description},
+		{"{{char}}'s personality: %s", card.Personality},
+		{"Scenario: %s", card.Scenario},
+		{"Example dialogue:\n%s", exampleDialogue(card.ExampleDialogue)},
+	} {
+		if strings.TrimSpace(part.text) != "" {
+			parts = append(parts, fmt.Sprintf(part.format, strings.TrimSpace(part.text)))
+		}
+	}
+
+	return expand(card, strings.Join(parts, "\n\n"))
+}
+
+// Greeting returns the message the character opens every conversation with, if any.
+func Greeting() string {
+	card := Current()
+	if card == nil {
+		return ""
+	}
+
+	return expand(card, strings.TrimSpace(card.FirstMessage))
+}
+
+// exampleDialogue drops the <START> markers separating the examples.
+func exampleDialogue(examples string) string {
+	lines := []string{}
+	for _, line := range strings.Split(examples, "\n") {
+		if strings.TrimSpace(line) != "<START>" {
+			lines = append(lines, line)
+		}
+	}
+
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// expand replaces the card's {{char}} & {{user}} placeholders.
+func expand(card *Card, text string) string {
+	user := viper.GetString("llm.character.user")
+	if user == "" {
+		user = strings.TrimSpace(strings.TrimRight(viper.GetString("llm.identifier_p"), ":"))
+	}
+
+	return strings.NewReplacer(
+		"{{char}}", card.Name,
+		"{{Char}}", card.Name,
+		"<BOT>", card.Name,
+		"{{user}}", user,
+		"{{User}}", user,
+		"<USER>", user,
+	).Replace(text)
+}
@@ -0,0 +1,89 @@
+package context
+
+import (
+	"strings"
+
+	"github.com/M-Ro/aurora-ai/internal/textgen/character"
+)
+
+// Find returns the index of the message with the id, or -1 if it isn't in the
+// conversation (any longer).
+func (c *ChatContext) Find(id string) int {
+	for i, ctxMsg := range c.Messages {
+		if id != "" && ctxMsg.Id == id {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// Before returns a copy of the conversation leading up to message i, to generate
+// another reply in its place from.
+func (c *ChatContext) Before(i int) *ChatContext {
+	messages := make([]ContextMessage, i)
+	copy(messages, c.Messages[:i])
+
+	labels := map[string]string{}
+	for id, label := range c.Labels {
+		labels[id] = label
+	}
+
+	return &ChatContext{Messages: messages, Summary: c.Summary, Labels: labels, Recalled: c.Recalled, Format: c.Format}
+}
+
+// Snapshot returns a copy of the conversation, to generate from while it moves on.
+func (c *ChatContext) Snapshot() *ChatContext {
+	return c.Before(len(c.Messages))
+}
+
+// LastReply returns the index of the bot's last message, or -1 if it hasn't spoken.
+func (c *ChatContext) LastReply() int {
+	botToken := character.BotToken()
+	for i := len(c.Messages) - 1; i >= 0; i-- {
+		if c.Messages[i].Author.Id == botToken {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// AlternativeCount returns how many replies there are to choose from for the message.
+func (m *ContextMessage) AlternativeCount() int {
+	if len(m.Alternatives) == 0 {
+		return 1
+	}
+
+	return len(m.Alternatives)
+}
+
+// AddAlternative adds another reply in place of the message, selecting it.
+func (m *ContextMessage) AddAlternative(reply string) {
+	if len(m.Alternatives) == 0 {
+		m.Alternatives = []string{m.Message}
+	}
+
+	m.Alternatives = append(m.Alternatives, strings.TrimSpace(reply))
+	m.SelectAlternative(len(m.Alternatives) - 1)
+}
+
+// SelectAlternative makes reply i the message, returning false if there is no such reply.
+func (m *ContextMessage) SelectAlternative(i int) bool {
+	if i < 0 || i >= len(m.Alternatives) {
+		return false
+	}
+
+	m.Selected = i
+	m.Message = m.Alternatives[i]
+
+	return true
+}
+
+// Extend adds a continuation to the end of the message.
+func (m *ContextMessage) Extend(continuation string) {
+	m.Message = strings.TrimRight(m.Message+continuation, " \t\n")
+	if len(m.Alternatives) > 0 {
+		m.Alternatives[m.Selected] = m.Message
+	}
+}
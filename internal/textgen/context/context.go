@@ -1,58 +1,169 @@
 package context
 
 import (
-	"strings"
+	"sync"
 
+	"github.com/M-Ro/aurora-ai/internal/textgen/character"
+	"github.com/M-Ro/aurora-ai/internal/textgen/prompt"
+	"github.com/M-Ro/aurora-ai/internal/tokenizer"
 	"github.com/spf13/viper"
 )
 
+// ChatContext is a conversation. A conversation shared through GetContext is read and
+// changed by the handlers of its channel at once, so they hold its lock while doing so;
+// its methods don't lock it themselves.
 type ChatContext struct {
+	mu sync.Mutex
+
 	Messages []ContextMessage
+	// Summary is what is remembered of the messages dropped from the conversation
+	Summary string
+	// Pending holds the dropped messages which are yet to be summarized
+	Pending []ContextMessage
+	// Labels holds the names users are labelled with in the prompt, keyed by author id,
+	// so they keep them once their messages are dropped
+	Labels map[string]string
+	// Recalled holds the past exchanges recalled from long-term memory for the next reply
+	Recalled []string
+	// Instructions replace the persona at the top of the prompt, if set
+	Instructions string
+	// Open leaves the bot's last message open in the prompt, in place of prompting a new
+	// reply, so the model continues it
+	Open bool
+	// Format is the layout of the prompt sent for the conversation, that of the generator
+	// replying to it, which its size is measured in. The default format if nil
+	Format *prompt.Format
+}
+
+// Lock locks the conversation for reading or changing it.
+func (c *ChatContext) Lock() {
+	c.mu.Lock()
+}
+
+// Unlock unlocks the conversation.
+func (c *ChatContext) Unlock() {
+	c.mu.Unlock()
 }
 
-// EnforceSize truncates old messages so we don't go over the token limit.
+// EnforceSize truncates old messages so we don't go over the token limit. If the
+// conversation is remembered, they are kept in Pending to be summarized. Room is left
+// for exchanges to be recalled into if recall is on.
 func (c *ChatContext) EnforceSize() {
-	limit := viper.GetInt("llm.settings.maximum_prompt_tokens")
+	limit := c.MessageLimit()
 
-	for c.TokenCount() > limit {
-		c.Messages = c.Messages[1:]
+	// Make room for a few more messages at once, so the summary isn't redone for every
+	// message from here on
+	if Remembers() && len(c.Messages) > 0 && c.TokenCount() > limit {
+		limit = limit * 3 / 4
 	}
+
+	for len(c.Messages) > 0 && c.TokenCount() > limit {
+		c.evict()
+	}
+}
+
+// MessageLimit is the most tokens the prompt may take up before messages are dropped.
+func (c *ChatContext) MessageLimit() int {
+	return viper.GetInt("llm.settings.maximum_prompt_tokens") - c.recallReserve()
 }
 
 // Adds a chat message to the prompt.
 func (c *ChatContext) AddMessage(ctxMsg *ContextMessage) error {
 	c.Messages = append(c.Messages, *ctxMsg)
+	c.assignLabel(ctxMsg.Author)
 	c.EnforceSize()
 
 	return nil
 }
 
-// Prompt returns the current conversation prompt.
+// Reset starts the conversation afresh, forgetting its summary and what was recalled
+// too. Like a new one, it opens with the character's greeting.
+func (c *ChatContext) Reset() {
+	c.Messages = []ContextMessage{}
+	c.Labels = nil
+	c.Summary = ""
+	c.Pending = nil
+	c.Recalled = nil
+	c.greet()
+}
+
+// Prompt returns the current conversation prompt in the default format.
 func (c *ChatContext) Prompt() string {
-	prompt := viper.GetString("llm.context")
-	botToken := viper.GetString("llm.identifier_b")
+	return c.PromptAs(prompt.Default())
+}
 
-	for _, ctxMsg := range c.Messages {
-		token := viper.GetString("llm.identifier_p")
+// PromptAs returns the current conversation prompt in format.
+func (c *ChatContext) PromptAs(format *prompt.Format) string {
+	text := format.SystemBlock(c.System())
+	speakers := c.attributedSpeakers()
 
-		if ctxMsg.Author.Id == botToken {
-			token = botToken
-		}
+	for i := range c.Messages {
+		text += c.formatMessage(format, speakers, i)
+	}
 
-		prompt += "\n" + token + " " + ctxMsg.Message
+	if c.continues() {
+		return text
 	}
 
 	// Reprompt the bot
-	prompt += "\n" + botToken
+	return text + format.PrimerBlock()
+}
 
-	return prompt
+// continues reports whether the prompt ends with the bot's last message left open.
+func (c *ChatContext) continues() bool {
+	return c.Open && len(c.Messages) > 0 && c.Messages[len(c.Messages)-1].Author.Id == character.BotToken()
 }
 
-// CalculateTokenCount gets the token count from the context.
-// HACK: this just counts words since we don't have access
-// to the tokenizer output.
+// attributedSpeakers returns the names users' messages are labelled with, or nil if
+// they are all labelled with the user identifier.
+func (c *ChatContext) attributedSpeakers() map[string]string {
+	if !AttributesNames() {
+		return nil
+	}
+
+	return c.Speakers()
+}
+
+// formatMessage returns message i as it is laid out in the prompt.
+func (c *ChatContext) formatMessage(format *prompt.Format, speakers map[string]string, i int) string {
+	ctxMsg := c.Messages[i]
+
+	role := prompt.RoleUser
+	if ctxMsg.Author.Id == character.BotToken() {
+		role = prompt.RoleAssistant
+	}
+
+	speaker := ""
+	if name, ok := speakers[ctxMsg.Author.Id]; ok {
+		speaker = name + ":"
+	}
+
+	if i == len(c.Messages)-1 && c.continues() {
+		return format.OpenMessage(role, ctxMsg.Author.Name, speaker, ctxMsg.Message, i == 0)
+	}
+
+	return format.Message(role, ctxMsg.Author.Name, speaker, ctxMsg.Message, i == 0)
+}
+
+// TokenCount gets the token count of the prompt in the conversation's format. Each
+// message is counted apart so their counts are reused as the conversation moves on,
+// which may be off by a token or so where a tokenizer would merge across them.
 func (c *ChatContext) TokenCount() int {
-	prompt := c.Prompt()
+	format := c.Format
+	if format == nil {
+		format = prompt.Default()
+	}
+
+	count := tokenizer.Count(format.SystemBlock(c.System()))
+	speakers := c.attributedSpeakers()
+
+	for i := range c.Messages {
+		count += tokenizer.Count(c.formatMessage(format, speakers, i))
+	}
+
+	if c.continues() {
+		return count
+	}
 
-	return len(strings.Fields(prompt))
+	return count + tokenizer.Count(format.PrimerBlock())
 }
@@ -1,13 +1,25 @@
 package context
 
+import (
+	"sync"
+
+	"github.com/M-Ro/aurora-ai/internal/textgen/character"
+)
+
 type ContextMap map[string]*ChatContext
 
 // contexts is a singleton map containing all chat contexts
-var contexts ContextMap = nil
+var (
+	contextsMu sync.Mutex
+	contexts   ContextMap = nil
+)
 
 // GetContext returns the ChatContext from the global state of all chat conversations.
 // If the context cannot be found, a new one is created and returned.
 func GetContext(key string) *ChatContext {
+	contextsMu.Lock()
+	defer contextsMu.Unlock()
+
 	if contexts == nil {
 		contexts = make(ContextMap)
 	}
@@ -16,7 +28,17 @@ func GetContext(key string) *ChatContext {
 	if !ok {
 		ctx = &ChatContext{}
 		contexts[key] = ctx
+		ctx.greet()
 	}
 
 	return ctx
 }
+
+// greet opens the conversation with the character's greeting, if it has one.
+func (c *ChatContext) greet() {
+	greeting := character.Greeting()
+	if greeting != "" {
+		msg := NewCtxMsgFromBotResponse(greeting)
+		c.AddMessage(&msg)
+	}
+}
@@ -3,8 +3,7 @@ package context
 import (
 	"strings"
 
-	"github.com/M-Ro/aurora-ai/internal/helpers"
-	"github.com/spf13/viper"
+	"github.com/M-Ro/aurora-ai/internal/textgen/character"
 )
 
 type Author struct {
@@ -13,44 +12,27 @@ type Author struct {
 }
 
 type ContextMessage struct {
+	// Id of the message in the chat, where there is one
+	Id      string
 	Author  Author
 	Message string
+
+	// Alternatives holds every reply generated in the bot's turn once it's regenerated,
+	// Message being the one selected
+	Alternatives []string
+	Selected     int
 }
 
-// NewCtxMsgFromBotResponse builds a new context message from the last bot response.
-// The bot returns the entire conversation each time, so we need to isolate the last message.
+// NewCtxMsgFromBotResponse builds a new context message from the bot's reply, which the
+// generator has already cut at the end of the bot's turn.
 func NewCtxMsgFromBotResponse(response string) ContextMessage {
-	// We need to remove the last string from the human token
-	// because the api is inconsistent and requires a suffix colon to inference without
-	// going schizo, but at termination doesn't bother to produce a suffix colon itself.
-	botToken := viper.GetString("llm.identifier_b")
-	eosToken := viper.GetString("llm.identifier_p")
-	eosToken = strings.TrimRight(eosToken, ":")
-
-	lB := strings.LastIndex(response, botToken)
-	lH := strings.LastIndex(response, eosToken)
-
-	msg := ""
-	// If lH > lB, the bot has re-prompted the user, so fetch the string upto that point
-	if lH > lB {
-		msg = helpers.Substr(
-			response,
-			lB+len(botToken),
-			lH-(lB+len(botToken)+1), // +1 to drop the \n the bot throws at the end
-		)
-	} else {
-		msg = helpers.Substr(
-			response,
-			lB+len(botToken),
-			len(response)-(lB+len(botToken)),
-		)
-	}
+	botToken := character.BotToken()
 
 	return ContextMessage{
 		Author: Author{
 			Id:   botToken,
 			Name: botToken,
 		},
-		Message: msg,
+		Message: strings.TrimSpace(response),
 	}
 }
@@ -1 +1,290 @@
 package context
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/M-Ro/aurora-ai/internal/textgen/prompt"
+	"github.com/spf13/viper"
+)
+
+func TestPrompt(t *testing.T) {
+	viper.Set("llm.context", "A chat.")
+	viper.Set("llm.identifier_p", "### Human:")
+	viper.Set("llm.identifier_b", "### Assistant:")
+	viper.Set("llm.settings.maximum_prompt_tokens", 2048)
+
+	c := ChatContext{}
+	c.AddMessage(&ContextMessage{Author: Author{Id: "1", Name: "alice"}, Message: "hi"})
+	c.AddMessage(&ContextMessage{Author: Author{Id: "### Assistant:"}, Message: "hello"})
+
+	want := "A chat.\n### Human: hi\n### Assistant: hello\n### Assistant:"
+	if got := c.Prompt(); got != want {
+		t.Errorf("got prompt %q, want %q", got, want)
+	}
+}
+
+func TestEnforceSize(t *testing.T) {
+	viper.Set("llm.context", "")
+	viper.Set("llm.settings.maximum_prompt_tokens", 20)
+
+	c := ChatContext{}
+	for i := 0; i < 10; i++ {
+		c.AddMessage(&ContextMessage{Author: Author{Id: "1"}, Message: strings.Repeat("word ", 3)})
+	}
+
+	if c.TokenCount() > 20 {
+		t.Errorf("token count %d over limit", c.TokenCount())
+	}
+
+	if len(c.Messages) == 0 || len(c.Messages) == 10 {
+		t.Errorf("expected some but not all messages to be kept, kept %d", len(c.Messages))
+	}
+}
+
+func TestPromptFormats(t *testing.T) {
+	viper.Set("llm.context", "A chat.")
+	viper.Set("llm.identifier_b", "### Assistant:")
+	viper.Set("llm.settings.maximum_prompt_tokens", 2048)
+
+	c := ChatContext{}
+	c.AddMessage(&ContextMessage{Author: Author{Id: "1", Name: "alice"}, Message: "hi"})
+	c.AddMessage(&ContextMessage{Author: Author{Id: "### Assistant:"}, Message: "hello"})
+	c.AddMessage(&ContextMessage{Author: Author{Id: "1", Name: "alice"}, Message: "bye"})
+
+	cases := map[string]string{
+		"chatml": "<|im_start|>system\nA chat.<|im_end|>\n" +
+			"<|im_start|>user\nhi<|im_end|>\n<|im_start|>assistant\nhello<|im_end|>\n" +
+			"<|im_start|>user\nbye<|im_end|>\n<|im_start|>assistant\n",
+		"llama-2": "[INST] <<SYS>>\nA chat.\n<</SYS>>\n\nhi [/INST] hello </s><s>[INST] bye [/INST]",
+	}
+
+	for name, want := range cases {
+		format, err := prompt.Get(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := c.PromptAs(format); got != want {
+			t.Errorf("%s: got prompt %q, want %q", name, got, want)
+		}
+	}
+
+	// The assistant may speak first, greeting the channel or once older messages are dropped
+	greeted := ChatContext{}
+	greeted.AddMessage(&ContextMessage{Author: Author{Id: "### Assistant:"}, Message: "Greetings"})
+	greeted.AddMessage(&ContextMessage{Author: Author{Id: "1", Name: "alice"}, Message: "hi"})
+
+	llama2, err := prompt.Get("llama-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "[INST] <<SYS>>\nA chat.\n<</SYS>>\n\n [/INST] Greetings </s><s>[INST] hi [/INST]"
+	if got := greeted.PromptAs(llama2); got != want {
+		t.Errorf("llama-2: got prompt %q, want %q", got, want)
+	}
+}
+
+func TestSpeakerAttribution(t *testing.T) {
+	viper.Set("llm.context", "A chat.")
+	viper.Set("llm.identifier_p", "### Human:")
+	viper.Set("llm.identifier_b", "### Assistant:")
+	viper.Set("llm.settings.maximum_prompt_tokens", 2048)
+	viper.Set("llm.attribution", AttributionNames)
+	defer viper.Set("llm.attribution", AttributionIdentifier)
+
+	c := ChatContext{}
+	c.AddMessage(&ContextMessage{Author: Author{Id: "1", Name: "alex"}, Message: "hi"})
+	c.AddMessage(&ContextMessage{Author: Author{Id: "2", Name: "Alex"}, Message: "hey"})
+	c.AddMessage(&ContextMessage{Author: Author{Id: "3", Name: "mal:\nicious"}, Message: "yo"})
+	c.AddMessage(&ContextMessage{Author: Author{Id: "### Assistant:"}, Message: "hello all"})
+
+	speakers := c.Speakers()
+	want := map[string]string{"1": "alex", "2": "Alex (2)", "3": "mal icious"}
+	for id, name := range want {
+		if speakers[id] != name {
+			t.Errorf("speaker %s named %q, want %q", id, speakers[id], name)
+		}
+	}
+
+	wantPrompt := "A chat.\nalex: hi\nAlex (2): hey\nmal icious: yo\n### Assistant: hello all\n### Assistant:"
+	if got := c.Prompt(); got != wantPrompt {
+		t.Errorf("got prompt %q, want %q", got, wantPrompt)
+	}
+
+	chatml, err := prompt.Get("chatml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := c.PromptAs(chatml); !strings.Contains(got, "<|im_start|>user\nAlex (2): hey<|im_end|>") {
+		t.Errorf("speaker missing from chatml prompt %q", got)
+	}
+
+	// Users keep their names once the messages they were named by are dropped
+	c.Messages = c.Messages[1:]
+	c.AddMessage(&ContextMessage{Author: Author{Id: "4", Name: "alex"}, Message: "sup"})
+	c.AddMessage(&ContextMessage{Author: Author{Id: "1", Name: "alex"}, Message: "back"})
+
+	speakers = c.Speakers()
+	want = map[string]string{"1": "alex", "2": "Alex (2)", "4": "alex (3)"}
+	for id, name := range want {
+		if speakers[id] != name {
+			t.Errorf("after dropping messages speaker %s named %q, want %q", id, speakers[id], name)
+		}
+	}
+}
+
+func TestAlternatives(t *testing.T) {
+	viper.Set("llm.identifier_b", "### Assistant:")
+	viper.Set("llm.settings.maximum_prompt_tokens", 2048)
+
+	c := ChatContext{}
+	c.AddMessage(&ContextMessage{Id: "10", Author: Author{Id: "1", Name: "alice"}, Message: "hi"})
+	reply := NewCtxMsgFromBotResponse("hello")
+	reply.Id = "11"
+	c.AddMessage(&reply)
+
+	i := c.Find("11")
+	if i != 1 || c.Find("12") != -1 {
+		t.Fatalf("found reply at %d", i)
+	}
+
+	snapshot := c.Before(i)
+	if len(snapshot.Messages) != 1 || snapshot.Messages[0].Message != "hi" {
+		t.Errorf("snapshot holds %v", snapshot.Messages)
+	}
+
+	ctxMsg := &c.Messages[i]
+	ctxMsg.AddAlternative(" hey there ")
+	if ctxMsg.Message != "hey there" || ctxMsg.AlternativeCount() != 2 || ctxMsg.Selected != 1 {
+		t.Errorf("after regenerating got %q, %d alternatives", ctxMsg.Message, ctxMsg.AlternativeCount())
+	}
+
+	if !ctxMsg.SelectAlternative(0) || ctxMsg.Message != "hello" {
+		t.Errorf("selecting the first alternative got %q", ctxMsg.Message)
+	}
+
+	if ctxMsg.SelectAlternative(2) {
+		t.Error("selected an alternative which doesn't exist")
+	}
+
+	if !strings.Contains(c.Prompt(), "### Assistant: hello\n") {
+		t.Errorf("selected alternative missing from prompt %q", c.Prompt())
+	}
+}
+
+func TestContinuePrompt(t *testing.T) {
+	viper.Set("llm.context", "A chat.")
+	viper.Set("llm.identifier_p", "### Human:")
+	viper.Set("llm.identifier_b", "### Assistant:")
+	viper.Set("llm.settings.maximum_prompt_tokens", 2048)
+
+	c := ChatContext{}
+	c.AddMessage(&ContextMessage{Author: Author{Id: "1", Name: "alice"}, Message: "tell me a story"})
+	reply := NewCtxMsgFromBotResponse("Once upon a time")
+	reply.Id = "11"
+	c.AddMessage(&reply)
+
+	snapshot := c.Before(c.LastReply() + 1)
+	snapshot.Open = true
+
+	want := "A chat.\n### Human: tell me a story\n### Assistant: Once upon a time"
+	if got := snapshot.Prompt(); got != want {
+		t.Errorf("got prompt %q, want %q", got, want)
+	}
+
+	chatml, err := prompt.Get("chatml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := snapshot.PromptAs(chatml); !strings.HasSuffix(got, "<|im_start|>assistant\nOnce upon a time") {
+		t.Errorf("reply not left open in %q", got)
+	}
+
+	c.Messages[1].Extend(" there was a bot. ")
+	if c.Messages[1].Message != "Once upon a time there was a bot." {
+		t.Errorf("got extended reply %q", c.Messages[1].Message)
+	}
+}
+
+func TestMemory(t *testing.T) {
+	viper.Set("llm.context", "A chat.")
+	viper.Set("llm.identifier_p", "### Human:")
+	viper.Set("llm.identifier_b", "### Assistant:")
+	viper.Set("llm.settings.maximum_prompt_tokens", 40)
+	viper.Set("llm.memory.enabled", true)
+	defer viper.Set("llm.memory.enabled", false)
+
+	c := ChatContext{}
+	for i := 0; i < 10; i++ {
+		c.AddMessage(&ContextMessage{Author: Author{Id: "1", Name: "alice"}, Message: strings.Repeat("word ", 3)})
+	}
+
+	if len(c.Pending) == 0 || len(c.Pending)+len(c.Messages) != 10 {
+		t.Errorf("expected the dropped messages to be pending, %d pending & %d kept", len(c.Pending), len(c.Messages))
+	}
+
+	if !strings.HasPrefix(c.Transcript(c.Pending), "alice: word") {
+		t.Errorf("got transcript %q", c.Transcript(c.Pending))
+	}
+
+	c.Summary = "Alice kept saying word."
+	if !strings.HasPrefix(c.Prompt(), "A chat.\n\nThe story so far:\nAlice kept saying word.\n") {
+		t.Errorf("summary missing from prompt %q", c.Prompt())
+	}
+
+	c.Reset()
+	if len(c.Messages) != 0 || len(c.Pending) != 0 || c.Prompt() != "A chat.\n### Assistant:" {
+		t.Errorf("conversation remembered after a reset in %q", c.Prompt())
+	}
+}
+
+func TestResetGreeting(t *testing.T) {
+	dir := t.TempDir()
+	err := ioutil.WriteFile(filepath.Join(dir, "aqua.json"), []byte(`{"name": "Aqua", "first_mes": "Hi {{user}}!"}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	viper.Set("llm.settings.maximum_prompt_tokens", 2048)
+	viper.Set("llm.character.dir", dir)
+	viper.Set("llm.character.user", "Kazuma")
+	viper.Set("llm.character.name", "aqua")
+	defer viper.Set("llm.character.name", "")
+
+	c := GetContext(t.Name())
+	c.AddMessage(&ContextMessage{Author: Author{Id: "1", Name: "kazuma"}, Message: "hi"})
+
+	// A reset conversation opens with the greeting, as a new one does
+	c.Reset()
+	if len(c.Messages) != 1 || c.Messages[0].Author.Id != "Aqua:" || c.Messages[0].Message != "Hi Kazuma!" {
+		t.Errorf("reset conversation holds %+v", c.Messages)
+	}
+}
+
+func TestRecallReserve(t *testing.T) {
+	viper.Set("llm.context", "")
+	viper.Set("llm.settings.maximum_prompt_tokens", 40)
+	viper.Set("llm.recall.enabled", true)
+	viper.Set("llm.recall.max_tokens", 10)
+	defer viper.Set("llm.recall.enabled", false)
+
+	c := ChatContext{}
+	for i := 0; i < 20; i++ {
+		c.AddMessage(&ContextMessage{Author: Author{Id: "1"}, Message: "word word"})
+	}
+
+	if c.TokenCount() > 30 {
+		t.Errorf("token count %d leaves no room to recall into", c.TokenCount())
+	}
+
+	c.Recalled = []string{"alice: my cat is called Tom"}
+	if !c.RecallFits() || !strings.Contains(c.Prompt(), "Earlier in the conversation:\nalice: my cat") {
+		t.Errorf("recalled exchange doesn't fit in prompt %q", c.Prompt())
+	}
+}
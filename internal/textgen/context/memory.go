@@ -0,0 +1,78 @@
+package context
+
+import (
+	"strings"
+
+	"github.com/M-Ro/aurora-ai/internal/textgen/character"
+	"github.com/spf13/viper"
+)
+
+// summaryHeading introduces the summary in the prompt.
+const summaryHeading = "The story so far:"
+
+// Remembers reports whether messages dropped from the conversation are summarized into
+// its memory rather than forgotten, as set by llm.memory.enabled.
+func Remembers() bool {
+	return viper.GetBool("llm.memory.enabled")
+}
+
+// SummaryBudget is the most tokens the summary may take up in the prompt.
+func SummaryBudget() int {
+	budget := viper.GetInt("llm.memory.summary_tokens")
+	if budget <= 0 {
+		return 256
+	}
+
+	return budget
+}
+
+// System returns what the prompt opens with: the persona, or Instructions if set, followed by
+// the summary of the conversation so far and the exchanges recalled for the next reply.
+func (c *ChatContext) System() string {
+	system := c.Instructions
+	if system == "" {
+		system = character.Persona()
+	}
+
+	if c.Summary != "" {
+		system += "\n\n" + summaryHeading + "\n" + c.Summary
+	}
+
+	if len(c.Recalled) > 0 {
+		system += "\n\n" + c.recallBlock()
+	}
+
+	return strings.TrimSpace(system)
+}
+
+// evict drops the oldest message, keeping it to be summarized if the conversation is
+// remembered.
+func (c *ChatContext) evict() {
+	if Remembers() {
+		c.Pending = append(c.Pending, c.Messages[0])
+	}
+
+	c.Messages = c.Messages[1:]
+}
+
+// Transcript returns messages as lines of who said what, for the model to summarize.
+func (c *ChatContext) Transcript(messages []ContextMessage) string {
+	botToken := character.BotToken()
+	speakers := c.Speakers()
+
+	lines := []string{}
+	for _, ctxMsg := range messages {
+		// Identifiers like "### Assistant:" are marked up for the prompt format
+		name := speakerName(strings.TrimLeft(botToken, "#* "))
+		if ctxMsg.Author.Id != botToken {
+			name = speakers[ctxMsg.Author.Id]
+			if name == "" {
+				name = speakerName(ctxMsg.Author.Name)
+			}
+		}
+
+		lines = append(lines, name+": "+ctxMsg.Message)
+	}
+
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,58 @@
+package context
+
+import (
+	"strings"
+
+	"github.com/M-Ro/aurora-ai/internal/tokenizer"
+	"github.com/spf13/viper"
+)
+
+// recallHeading introduces the exchanges recalled from long-term memory in the prompt.
+const recallHeading = "Earlier in the conversation:"
+
+// Recalls reports whether past exchanges relevant to the conversation are recalled from
+// long-term memory into the prompt, as set by llm.recall.enabled.
+func Recalls() bool {
+	return viper.GetBool("llm.recall.enabled")
+}
+
+// RecallBudget is the most tokens recalled exchanges may take up in the prompt.
+func RecallBudget() int {
+	budget := viper.GetInt("llm.recall.max_tokens")
+	if budget <= 0 {
+		return 256
+	}
+
+	return budget
+}
+
+// recallBlock returns the recalled exchanges as they are laid out in the prompt.
+func (c *ChatContext) recallBlock() string {
+	if len(c.Recalled) == 0 {
+		return ""
+	}
+
+	return recallHeading + "\n" + strings.Join(c.Recalled, "\n\n")
+}
+
+// recallReserve returns the tokens kept free of messages for exchanges to be recalled
+// into, less those already recalled.
+func (c *ChatContext) recallReserve() int {
+	if !Recalls() {
+		return 0
+	}
+
+	reserve := RecallBudget() - tokenizer.Count(c.recallBlock())
+	if reserve < 0 {
+		return 0
+	}
+
+	return reserve
+}
+
+// RecallFits reports whether the recalled exchanges fit in their budget and leave the
+// prompt within its limit.
+func (c *ChatContext) RecallFits() bool {
+	return tokenizer.Count(c.recallBlock()) <= RecallBudget() &&
+		c.TokenCount() <= viper.GetInt("llm.settings.maximum_prompt_tokens")
+}
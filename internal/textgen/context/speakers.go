@@ -0,0 +1,122 @@
+package context
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/M-Ro/aurora-ai/internal/textgen/character"
+	"github.com/spf13/viper"
+)
+
+// Ways of attributing the users' messages in the prompt, set by llm.attribution.
+const (
+	// AttributionIdentifier labels every user's message with llm.identifier_p
+	AttributionIdentifier = "identifier"
+	// AttributionNames labels each message with its author's name
+	AttributionNames = "names"
+)
+
+// AttributesNames reports whether messages are labelled with their author's name.
+func AttributesNames() bool {
+	return viper.GetString("llm.attribution") == AttributionNames
+}
+
+// Speakers returns the name each user in the conversation goes by in the prompt, keyed
+// by author id. Names are unique, those taken already get a number added in order of
+// appearance, and never clash with the bot's or the identifiers. Users keep the name
+// they were labelled with as long as they go by it, after their first messages are
+// dropped too.
+func (c *ChatContext) Speakers() map[string]string {
+	botToken := character.BotToken()
+	taken := c.takenNames()
+
+	speakers := map[string]string{}
+	for _, ctxMsg := range c.Messages {
+		id := ctxMsg.Author.Id
+		if id == botToken {
+			continue
+		}
+
+		if _, ok := speakers[id]; ok {
+			continue
+		}
+
+		name, ok := c.label(ctxMsg.Author)
+		if !ok {
+			name = uniqueName(ctxMsg.Author.Name, taken)
+		}
+
+		taken[strings.ToLower(name)] = true
+		speakers[id] = name
+	}
+
+	return speakers
+}
+
+// label returns the name the author was labelled with, if they still go by it.
+func (c *ChatContext) label(author Author) (string, bool) {
+	label, ok := c.Labels[author.Id]
+	base := speakerName(author.Name)
+
+	return label, ok && (label == base || strings.HasPrefix(label, base+" ("))
+}
+
+// assignLabel labels the author of a message added to the conversation with the name
+// they go by from here on.
+func (c *ChatContext) assignLabel(author Author) {
+	if author.Id == character.BotToken() {
+		return
+	}
+
+	if _, ok := c.label(author); ok {
+		return
+	}
+
+	if c.Labels == nil {
+		c.Labels = map[string]string{}
+	}
+
+	// A user who changed their name frees up the old one
+	delete(c.Labels, author.Id)
+	c.Labels[author.Id] = uniqueName(author.Name, c.takenNames())
+}
+
+// takenNames returns the names users can't be labelled with, lowercased: the bot's,
+// the identifiers and those of users labelled already.
+func (c *ChatContext) takenNames() map[string]bool {
+	taken := map[string]bool{}
+	for _, token := range []string{character.BotToken(), viper.GetString("llm.identifier_p")} {
+		taken[strings.ToLower(speakerName(token))] = true
+	}
+
+	for _, label := range c.Labels {
+		taken[strings.ToLower(label)] = true
+	}
+
+	return taken
+}
+
+// uniqueName returns the name to label a user with, numbered if it's taken.
+func uniqueName(name string, taken map[string]bool) string {
+	base := speakerName(name)
+	name = base
+	for n := 2; taken[strings.ToLower(name)]; n++ {
+		name = fmt.Sprintf("%s (%d)", base, n)
+	}
+
+	return name
+}
+
+// speakerName cleans up a name to label lines of the prompt with, so it can't break
+// out of its line or be mistaken for the end of the label.
+func speakerName(name string) string {
+	name = strings.Join(strings.Fields(name), " ")
+	name = strings.TrimSpace(strings.Trim(name, ":"))
+	name = strings.ReplaceAll(name, ":", "")
+
+	if name == "" {
+		return "User"
+	}
+
+	return name
+}
@@ -0,0 +1,50 @@
+package textgen
+
+import (
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// InferenceDeltaFunc is called with the text added to the reply since the previous call.
+// reply holds the whole reply so far, should it be wanted.
+type InferenceDeltaFunc func(delta string, reply *Reply)
+
+// Reply accumulates a streamed reply from its deltas.
+type Reply struct {
+	text strings.Builder
+}
+
+// Text returns the reply so far.
+func (r *Reply) Text() string {
+	return r.text.String()
+}
+
+// deltaTracker turns the whole-reply updates backends produce into deltas.
+type deltaTracker struct {
+	reply   Reply
+	onDelta InferenceDeltaFunc
+}
+
+// update emits whatever output adds to the reply so far. Backends only ever extend the
+// reply, but should one rewrite what was already sent, that can't be taken back, so
+// nothing is emitted until it extends the reply again.
+func (d *deltaTracker) update(output string) {
+	if d.onDelta == nil {
+		return
+	}
+
+	sent := d.reply.Text()
+	if !strings.HasPrefix(output, sent) {
+		logrus.Debugf("Reply rewritten mid stream, %q no longer follows %q", output, sent)
+		return
+	}
+
+	delta := output[len(sent):]
+	if delta == "" {
+		return
+	}
+
+	d.reply.text.WriteString(delta)
+	d.onDelta(delta, &d.reply)
+}
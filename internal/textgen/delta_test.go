@@ -0,0 +1,56 @@
+package textgen
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestDeltaTracker(t *testing.T) {
+	deltas := []string{}
+	tracker := deltaTracker{onDelta: func(delta string, reply *Reply) {
+		deltas = append(deltas, delta)
+	}}
+
+	for _, output := range []string{"He", "Hello", "Hello", "Hallo", "Hello there"} {
+		tracker.update(output)
+	}
+
+	expected := []string{"He", "llo", " there"}
+	if !reflect.DeepEqual(deltas, expected) {
+		t.Errorf("Got deltas %q, expected %q", deltas, expected)
+	}
+
+	if tracker.reply.Text() != "Hello there" {
+		t.Errorf("Got reply %q", tracker.reply.Text())
+	}
+}
+
+func TestStopGeneratorDeltas(t *testing.T) {
+	fake := &FakeGenerator{Reply: "Sure thing!\n### Human: thanks"}
+	generator := &StopGenerator{Generator: fake}
+
+	deltas := []string{}
+	streamed := ""
+	completed := ""
+	_, err := generator.Generate(context.Background(), &Job{
+		Context: conversation("hello"),
+		OnDelta: func(delta string, reply *Reply) {
+			deltas = append(deltas, delta)
+			streamed = reply.Text()
+		},
+		OnComplete: func(output string) { completed = output },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"Sure ", "thing!"}
+	if !reflect.DeepEqual(deltas, expected) {
+		t.Errorf("Got deltas %q, expected %q", deltas, expected)
+	}
+
+	if streamed != completed {
+		t.Errorf("Streamed %q but completed with %q", streamed, completed)
+	}
+}
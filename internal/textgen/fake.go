@@ -0,0 +1,36 @@
+package textgen
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// FakeReply is what the fake backend answers every job with.
+const FakeReply = "Hello! I'm a fake generator, so this is all I have to say."
+
+// FakeGenerator streams a canned reply a word at a time, standing in for a real backend
+// in tests and when trying out the bot without one.
+type FakeGenerator struct {
+	Reply string
+	// Interval is waited between words
+	Interval time.Duration
+}
+
+func (g *FakeGenerator) Generate(ctx context.Context, job *Job) (Usage, error) {
+	output := ""
+	for _, word := range strings.SplitAfter(g.Reply, " ") {
+		select {
+		case <-time.After(g.Interval):
+		case <-ctx.Done():
+			return Usage{}, ctx.Err()
+		}
+
+		output += word
+		job.OnUpdate(output)
+	}
+
+	job.OnComplete(output)
+
+	return estimateUsage(job.Context, output), nil
+}
@@ -0,0 +1,277 @@
+package textgen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/M-Ro/aurora-ai/internal/gradio"
+	chat "github.com/M-Ro/aurora-ai/internal/textgen/context"
+	"github.com/M-Ro/aurora-ai/internal/textgen/prompt"
+	"github.com/M-Ro/aurora-ai/internal/tokenizer"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+type InferenceUpdateFunc func(string)
+type InferenceCompleteFunc func(string)
+
+var (
+	ErrUnknownBackend   = errors.New("Unknown llm backend")
+	ErrUnknownGenerator = errors.New("Unknown generator")
+)
+
+// DefaultGenerator is the name of the generator configured directly under llm.
+const DefaultGenerator = "default"
+
+// Job is a request for the bot's reply to a conversation.
+type Job struct {
+	// SessionKey identifies the conversation, backends which keep history server side
+	// keep it apart per key
+	SessionKey string
+	Context    *chat.ChatContext
+
+	// OnQueue is called with our place in the queue while waiting, and may be nil
+	OnQueue gradio.QueueUpdateFunc
+	// OnUpdate is called with the whole reply so far each time it grows
+	OnUpdate InferenceUpdateFunc
+	// OnDelta is called with only what the reply grew by, for consumers printing it
+	// as it comes. Either may be nil when the job is run through GetGenerator.
+	OnDelta    InferenceDeltaFunc
+	OnComplete InferenceCompleteFunc
+}
+
+// Usage is how many tokens a job took.
+type Usage struct {
+	PromptTokens     int
+	CompletionTokens int
+	// Estimated is set when the backend didn't report usage, so it was counted by us
+	Estimated bool
+}
+
+// estimateUsage counts the usage of a job whose backend doesn't report it.
+func estimateUsage(chatCtx *chat.ChatContext, output string) Usage {
+	return Usage{
+		PromptTokens:     chatCtx.TokenCount(),
+		CompletionTokens: tokenizer.Count(output),
+		Estimated:        true,
+	}
+}
+
+// Generator produces the bot's replies.
+type Generator interface {
+	// Generate streams the reply to job.Context through the job's callbacks.
+	// Cancelling ctx abandons the job, returning ctx.Err().
+	Generate(ctx context.Context, job *Job) (Usage, error)
+}
+
+// EndpointResolver is implemented by generators which have to look up their endpoints
+// before first use.
+type EndpointResolver interface {
+	ResolveEndpoints(ctx context.Context) error
+}
+
+// GeneratorFactory builds a generator from the settings under the config key, e.g llm.
+type GeneratorFactory func(key string) (Generator, error)
+
+var (
+	registryMu sync.Mutex
+	// backends maps the names used by <key>.backend to their factories
+	backends = map[string]GeneratorFactory{
+		BackendGradio:   func(key string) (Generator, error) { return &GradioGenerator{Key: key}, nil },
+		BackendWebuiApi: func(key string) (Generator, error) { return &WebuiApiGenerator{Key: key}, nil },
+		BackendOpenAI:   func(key string) (Generator, error) { return &OpenAIGenerator{Key: key}, nil },
+		BackendFake:     func(key string) (Generator, error) { return &FakeGenerator{Reply: FakeReply}, nil },
+	}
+	// generators holds generators registered in code, taking precedence over config
+	generators = map[string]Generator{}
+)
+
+// RegisterBackend makes a backend selectable by name through <key>.backend.
+func RegisterBackend(name string, factory GeneratorFactory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	backends[name] = factory
+}
+
+// Register installs a generator under name, in place of any configured with that name.
+func Register(name string, generator Generator) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	generators[name] = generator
+}
+
+// Unregister removes a generator installed by Register.
+func Unregister(name string) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	delete(generators, name)
+}
+
+// generatorKey returns the config key of the named generator. The default generator is
+// configured directly under llm, the rest under llm.generators.
+func generatorKey(name string) string {
+	if name == "" || name == DefaultGenerator {
+		return "llm"
+	}
+
+	return "llm.generators." + name
+}
+
+// NewGenerator builds the generator configured under key, its kind chosen by
+// <key>.backend.
+func NewGenerator(key string) (Generator, error) {
+	backend := viper.GetString(key + ".backend")
+	if backend == "" {
+		backend = BackendGradio
+	}
+
+	registryMu.Lock()
+	factory, ok := backends[backend]
+	registryMu.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownBackend, backend)
+	}
+
+	return factory(key)
+}
+
+// GetGenerator returns the named generator, an empty name being the default. Its
+// replies are cut at the conversation's stop strings.
+func GetGenerator(name string) (Generator, error) {
+	if name == "" {
+		name = DefaultGenerator
+	}
+
+	registryMu.Lock()
+	generator, ok := generators[name]
+	registryMu.Unlock()
+
+	if !ok {
+		if name != DefaultGenerator && !viper.IsSet(generatorKey(name)) {
+			return nil, fmt.Errorf("%w %q", ErrUnknownGenerator, name)
+		}
+
+		var err error
+		generator, err = NewGenerator(generatorKey(name))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	format, err := prompt.ForKey(generatorKey(name))
+	if err != nil {
+		return nil, err
+	}
+
+	return &StopGenerator{Generator: generator, Format: format}, nil
+}
+
+// GeneratorFor returns the generator serving a discord channel, going by llm.routes.
+// A route for the channel wins over one for its guild, the rest use the default.
+func GeneratorFor(guildId string, channelId string) (Generator, error) {
+	name := viper.GetString("llm.routes.channels." + channelId)
+	if name == "" && guildId != "" {
+		name = viper.GetString("llm.routes.guilds." + guildId)
+	}
+
+	return GetGenerator(name)
+}
+
+// FormatOf returns the prompt format of a generator returned by GetGenerator, which a
+// conversation replied to by it is sized in.
+func FormatOf(generator Generator) *prompt.Format {
+	if g, ok := generator.(*StopGenerator); ok && g.Format != nil {
+		return g.Format
+	}
+
+	return prompt.Default()
+}
+
+// GeneratorNames returns the names of every configured or registered generator.
+func GeneratorNames() []string {
+	names := map[string]bool{DefaultGenerator: true}
+	for name := range viper.GetStringMap("llm.generators") {
+		names[name] = true
+	}
+
+	registryMu.Lock()
+	for name := range generators {
+		names[name] = true
+	}
+	registryMu.Unlock()
+
+	sorted := []string{}
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	return sorted
+}
+
+// ResolveEndpoints looks up the endpoints of every generator which needs them. One
+// missing endpoints fails immediately, other failures are returned once all were tried.
+func ResolveEndpoints(ctx context.Context) error {
+	var lastErr error
+
+	for _, name := range GeneratorNames() {
+		generator, err := GetGenerator(name)
+		if err != nil {
+			return err
+		}
+
+		resolver, ok := generator.(*StopGenerator).Generator.(EndpointResolver)
+		if !ok {
+			continue
+		}
+
+		err = resolver.ResolveEndpoints(ctx)
+
+		var missing *gradio.MissingEndpointsError
+		if errors.As(err, &missing) {
+			return err
+		}
+
+		if err != nil {
+			logrus.Warnf("Could not resolve endpoints of generator %s: %s", name, err)
+			lastErr = err
+		}
+	}
+
+	return lastErr
+}
+
+// RunInference generates the bot's response to the conversation in chatCtx with the
+// default generator. sessionKey identifies the conversation.
+// onQueue is called with our place in the queue while waiting, and may be nil.
+// Cancelling ctx abandons the job, returning ctx.Err().
+func RunInference(
+	ctx context.Context,
+	sessionKey string,
+	chatCtx *chat.ChatContext,
+	onQueue gradio.QueueUpdateFunc,
+	onUpdate InferenceUpdateFunc,
+	onComplete InferenceCompleteFunc,
+) error {
+	generator, err := GetGenerator(DefaultGenerator)
+	if err != nil {
+		return err
+	}
+
+	_, err = generator.Generate(ctx, &Job{
+		SessionKey: sessionKey,
+		Context:    chatCtx,
+		OnQueue:    onQueue,
+		OnUpdate:   onUpdate,
+		OnComplete: onComplete,
+	})
+
+	return err
+}
@@ -0,0 +1,64 @@
+package textgen
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestGeneratorFor(t *testing.T) {
+	viper.Set("llm.generators", map[string]interface{}{
+		"fast":  map[string]interface{}{"backend": BackendOpenAI},
+		"dummy": map[string]interface{}{"backend": BackendFake},
+	})
+	viper.Set("llm.routes", map[string]interface{}{
+		"channels": map[string]interface{}{"10": "dummy"},
+		"guilds":   map[string]interface{}{"1": "fast"},
+	})
+	defer viper.Set("llm.generators", nil)
+	defer viper.Set("llm.routes", nil)
+
+	cases := []struct {
+		guild, channel string
+		check          func(g Generator) bool
+	}{
+		{"1", "10", func(g Generator) bool { _, ok := g.(*FakeGenerator); return ok }},
+		{"1", "11", func(g Generator) bool { o, ok := g.(*OpenAIGenerator); return ok && o.Key == "llm.generators.fast" }},
+		{"2", "12", func(g Generator) bool { o, ok := g.(*GradioGenerator); return ok && o.Key == "llm" }},
+	}
+
+	for _, c := range cases {
+		generator, err := GeneratorFor(c.guild, c.channel)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !c.check(generator.(*StopGenerator).Generator) {
+			t.Errorf("guild %s channel %s got %#v", c.guild, c.channel, generator)
+		}
+	}
+
+	_, err := GetGenerator("missing")
+	if !errors.Is(err, ErrUnknownGenerator) {
+		t.Errorf("expected ErrUnknownGenerator, got %v", err)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	Register(DefaultGenerator, &FakeGenerator{Reply: testReply})
+	defer Unregister(DefaultGenerator)
+
+	completed := ""
+	err := RunInference(context.Background(), t.Name(), conversation("hello"), nil, func(string) {}, func(output string) {
+		completed = output
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if completed != testReply {
+		t.Errorf("completed with %q, want %q", completed, testReply)
+	}
+}
@@ -0,0 +1,96 @@
+package textgen
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	chat "github.com/M-Ro/aurora-ai/internal/textgen/context"
+	"github.com/M-Ro/aurora-ai/internal/tokenizer"
+)
+
+// summarizeInstructions stand in for the persona when the model summarizes.
+const summarizeInstructions = "You keep a running summary of a conversation, so it can " +
+	"go on after the older messages are gone. Keep the names, facts, events and " +
+	"promises which matter later, in the order they happened."
+
+// Summarize folds the messages dropped from the conversation into its summary, which
+// is kept within chat.SummaryBudget. The messages are kept pending should it fail. The
+// conversation is locked only while it's read and updated, not while generating.
+func Summarize(ctx context.Context, generator Generator, sessionKey string, chatCtx *chat.ChatContext) error {
+	chatCtx.Lock()
+	previous := chatCtx.Summary
+	pending := chatCtx.Pending
+	transcript := chatCtx.Transcript(pending)
+	chatCtx.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	budget := chat.SummaryBudget()
+
+	summary := previous
+	if summary == "" {
+		summary = "(nothing yet)"
+	}
+
+	request := fmt.Sprintf(
+		"Summary so far:\n%s\n\nNew messages:\n%s\n\n"+
+			"Rewrite the summary so it covers the new messages too, in at most %d words. "+
+			"Reply with the summary alone.",
+		summary,
+		transcript,
+		budget*3/4,
+	)
+
+	output := ""
+	_, err := generator.Generate(ctx, &Job{
+		SessionKey: sessionKey + "/memory",
+		Context: &chat.ChatContext{
+			Instructions: summarizeInstructions,
+			Messages: []chat.ContextMessage{
+				{Author: chat.Author{Id: "memory", Name: "User"}, Message: request},
+			},
+		},
+		OnUpdate:   func(string) {},
+		OnComplete: func(reply string) { output = reply },
+	})
+	if err != nil {
+		return err
+	}
+
+	chatCtx.Lock()
+	defer chatCtx.Unlock()
+
+	// Another reply may have summarized the messages meanwhile, or the conversation been
+	// reset, leaving ours stale
+	if chatCtx.Summary != previous || len(chatCtx.Pending) < len(pending) {
+		return nil
+	}
+
+	chatCtx.Summary = fitSummary(strings.TrimSpace(output), budget)
+	chatCtx.Pending = chatCtx.Pending[len(pending):]
+
+	// A longer summary leaves less room for the messages
+	chatCtx.EnforceSize()
+
+	return nil
+}
+
+// fitSummary drops the oldest sentences of the summary until it fits in budget tokens.
+func fitSummary(summary string, budget int) string {
+	for summary != "" && tokenizer.Count(summary) > budget {
+		end := strings.IndexAny(summary, ".!?\n")
+		if end < 0 || end == len(summary)-1 {
+			// Down to a single sentence, so go word by word
+			words := strings.Fields(summary)
+			summary = strings.Join(words[1:], " ")
+			continue
+		}
+
+		summary = strings.TrimSpace(summary[end+1:])
+	}
+
+	return summary
+}
@@ -0,0 +1,80 @@
+package textgen
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	chat "github.com/M-Ro/aurora-ai/internal/textgen/context"
+	"github.com/spf13/viper"
+)
+
+func TestSummarize(t *testing.T) {
+	viper.Set("llm.memory.summary_tokens", 6)
+	defer viper.Set("llm.memory.summary_tokens", 0)
+
+	chatCtx := conversation("hello")
+	chatCtx.Pending = []chat.ContextMessage{
+		{Author: chat.Author{Id: "1", Name: "alice"}, Message: "my cat is called Tom"},
+	}
+
+	generator := &FakeGenerator{Reply: "Alice said hello. Alice has a cat called Tom."}
+	err := Summarize(context.Background(), generator, "test", chatCtx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The oldest sentence goes to fit the budget
+	if chatCtx.Summary != "Alice has a cat called Tom." {
+		t.Errorf("got summary %q", chatCtx.Summary)
+	}
+
+	if len(chatCtx.Pending) != 0 {
+		t.Errorf("%d messages still pending", len(chatCtx.Pending))
+	}
+}
+
+// racingGenerator changes the conversation while generating, as another reply would.
+type racingGenerator struct {
+	FakeGenerator
+	race func()
+}
+
+func (g *racingGenerator) Generate(ctx context.Context, job *Job) (Usage, error) {
+	g.race()
+	return g.FakeGenerator.Generate(ctx, job)
+}
+
+func TestSummarizeStale(t *testing.T) {
+	chatCtx := conversation("hello")
+	chatCtx.Pending = []chat.ContextMessage{
+		{Author: chat.Author{Id: "1", Name: "alice"}, Message: "my cat is called Tom"},
+	}
+
+	// The conversation is reset while the summary is written
+	generator := &racingGenerator{FakeGenerator: FakeGenerator{Reply: "Alice has a cat."}, race: func() {
+		chatCtx.Lock()
+		chatCtx.Pending = nil
+		chatCtx.Unlock()
+	}}
+
+	err := Summarize(context.Background(), generator, "test", chatCtx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if chatCtx.Summary != "" {
+		t.Errorf("stale summary %q kept", chatCtx.Summary)
+	}
+}
+
+func TestFitSummary(t *testing.T) {
+	summary := fitSummary("one two three four five six", 3)
+	if summary != "four five six" {
+		t.Errorf("got %q", summary)
+	}
+
+	if strings.TrimSpace(fitSummary("short.", 10)) != "short." {
+		t.Error("summary within budget was changed")
+	}
+}
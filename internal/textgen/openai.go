@@ -0,0 +1,270 @@
+package textgen
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/M-Ro/aurora-ai/api"
+	"github.com/M-Ro/aurora-ai/internal/gradio"
+	"github.com/M-Ro/aurora-ai/internal/helpers"
+	"github.com/M-Ro/aurora-ai/internal/textgen/character"
+	chat "github.com/M-Ro/aurora-ai/internal/textgen/context"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// openAISettings configures an OpenAI-compatible server, such as llama.cpp's server,
+// vLLM or LM Studio.
+type openAISettings struct {
+	// BaseURL is the url the api paths hang off, e.g http://127.0.0.1:8080/v1
+	BaseURL string `mapstructure:"base_url"`
+	// APIKey is sent as a bearer token if set
+	APIKey string `mapstructure:"api_key"`
+	// Model is the name of the model to run, servers hosting a single model ignore it
+	Model string `mapstructure:"model"`
+	// Stream tokens as they are generated rather than waiting for the whole reply
+	Stream bool `mapstructure:"stream"`
+}
+
+var openAIClient = &http.Client{
+	Transport: &http.Transport{Proxy: http.ProxyFromEnvironment},
+}
+
+// chatMessages converts the conversation into role tagged messages, with the persona
+// & summary as the system prompt.
+func chatMessages(chatCtx *chat.ChatContext) []api.ChatMessage {
+	botToken := character.BotToken()
+	messages := []api.ChatMessage{}
+
+	persona := chatCtx.System()
+	if persona != "" {
+		messages = append(messages, api.ChatMessage{Role: api.RoleSystem, Content: persona})
+	}
+
+	// The name field only allows a few characters, so attributed messages are prefixed
+	// with their author's name instead
+	speakers := map[string]string{}
+	if chat.AttributesNames() {
+		speakers = chatCtx.Speakers()
+	}
+
+	for _, ctxMsg := range chatCtx.Messages {
+		role := api.RoleUser
+		if ctxMsg.Author.Id == botToken {
+			role = api.RoleAssistant
+		}
+
+		content := ctxMsg.Message
+		if name, ok := speakers[ctxMsg.Author.Id]; ok {
+			content = name + ": " + content
+		}
+
+		messages = append(messages, api.ChatMessage{Role: role, Content: content})
+	}
+
+	return messages
+}
+
+// OpenAIGenerator uses an OpenAI-compatible /chat/completions endpoint, configured by
+// <Key>.openai.
+type OpenAIGenerator struct {
+	Key string
+}
+
+// Generate runs the job, usage is as reported by the server where it does so.
+func (g *OpenAIGenerator) Generate(ctx context.Context, job *Job) (Usage, error) {
+	settings := openAISettings{}
+	err := viper.UnmarshalKey(g.Key+".openai", &settings)
+	if err != nil {
+		return Usage{}, err
+	}
+
+	params, err := getParameters()
+	if err != nil {
+		return Usage{}, err
+	}
+
+	completion := api.ChatCompletionRequest{
+		Model:       settings.Model,
+		Messages:    chatMessages(job.Context),
+		Stream:      settings.Stream,
+		MaxTokens:   params.MaxNewTokens,
+		Temperature: params.Temperature,
+		TopP:        params.TopP,
+		Stop:        params.StoppingStrings,
+	}
+
+	if params.Seed >= 0 {
+		completion.Seed = &params.Seed
+	}
+
+	if job.Context.Open {
+		noGenerationPrompt := false
+		completion.ContinueFinalMessage = true
+		completion.AddGenerationPrompt = &noGenerationPrompt
+	}
+
+	output := ""
+	onComplete := func(reply string) {
+		output = reply
+		job.OnComplete(reply)
+	}
+
+	usage, err := runCompletion(ctx, &settings, &completion, job.OnUpdate, onComplete)
+	if err != nil {
+		return Usage{}, err
+	}
+
+	if usage == nil {
+		return estimateUsage(job.Context, output), nil
+	}
+
+	return Usage{
+		PromptTokens:     usage.PromptTokens,
+		CompletionTokens: usage.CompletionTokens,
+	}, nil
+}
+
+// runCompletion posts the completion, returning the usage reported by the server if any.
+func runCompletion(
+	ctx context.Context,
+	settings *openAISettings,
+	completion *api.ChatCompletionRequest,
+	onUpdate InferenceUpdateFunc,
+	onComplete InferenceCompleteFunc,
+) (*api.ChatCompletionUsage, error) {
+	body, err := json.Marshal(completion)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodPost,
+		strings.TrimRight(settings.BaseURL, "/")+"/chat/completions",
+		bytes.NewReader(body),
+	)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	if settings.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+settings.APIKey)
+	}
+
+	res, err := openAIClient.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		logrus.Error(err)
+		return nil, gradio.ErrNoConnect
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, openAIError(res)
+	}
+
+	if settings.Stream {
+		return readOpenAIStream(ctx, res, onUpdate, onComplete)
+	}
+
+	generated := api.ChatCompletionResponse{}
+	err = json.NewDecoder(res.Body).Decode(&generated)
+	if err != nil {
+		return nil, &gradio.ProtocolError{Reason: "malformed completion", Err: err}
+	}
+
+	if len(generated.Choices) == 0 || generated.Choices[0].Message == nil {
+		return nil, &gradio.ProtocolError{Reason: "completion has no choices"}
+	}
+
+	output := generated.Choices[0].Message.Content
+	onUpdate(output)
+	onComplete(output)
+
+	return generated.Usage, nil
+}
+
+// readOpenAIStream reads the deltas of a streamed completion from its event stream.
+func readOpenAIStream(
+	ctx context.Context,
+	res *http.Response,
+	onUpdate InferenceUpdateFunc,
+	onComplete InferenceCompleteFunc,
+) (*api.ChatCompletionUsage, error) {
+	output := ""
+	done := false
+	var usage *api.ChatCompletionUsage
+
+	var streamErr error
+	err := helpers.ReadEventStream(res.Body, func(data []byte) bool {
+		if string(data) == api.ChatCompletionDone {
+			done = true
+			return false
+		}
+
+		chunk := api.ChatCompletionResponse{}
+		err := json.Unmarshal(data, &chunk)
+		if err != nil {
+			streamErr = &gradio.ProtocolError{Reason: "malformed completion chunk", Err: err}
+			return false
+		}
+
+		// Servers which report usage when streaming do so with the final chunk
+		if chunk.Usage != nil {
+			usage = chunk.Usage
+		}
+
+		for _, choice := range chunk.Choices {
+			if choice.Delta != nil && choice.Delta.Content != "" {
+				output += choice.Delta.Content
+				onUpdate(output)
+			}
+
+			// Not every server sends [DONE] after the final chunk
+			if choice.FinishReason != nil && *choice.FinishReason != "" {
+				done = true
+			}
+		}
+
+		return true
+	})
+
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	if streamErr != nil {
+		return nil, streamErr
+	}
+
+	if err != nil || !done {
+		logrus.Error("Completion stream ended early: ", err)
+		return nil, gradio.ErrConnectionLost
+	}
+
+	onComplete(output)
+
+	return usage, nil
+}
+
+// openAIError returns the error of a rejected request, using the message from the body
+// if it has one.
+func openAIError(res *http.Response) error {
+	body, _ := ioutil.ReadAll(res.Body)
+
+	rejected := api.ChatCompletionError{}
+	if json.Unmarshal(body, &rejected) == nil && rejected.Error.Message != "" {
+		return &gradio.UpstreamError{Message: rejected.Error.Message}
+	}
+
+	return &gradio.UpstreamError{Message: res.Status}
+}
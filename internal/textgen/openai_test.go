@@ -0,0 +1,125 @@
+package textgen
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/M-Ro/aurora-ai/api"
+	chat "github.com/M-Ro/aurora-ai/internal/textgen/context"
+	"github.com/spf13/viper"
+)
+
+// startFakeOpenAI serves /v1/chat/completions answering with testReply, and points the
+// llm config at it.
+func startFakeOpenAI(t *testing.T, stream bool) *[]api.ChatCompletionRequest {
+	requests := []api.ChatCompletionRequest{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" || r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error": {"message": "bad key"}}`)
+			return
+		}
+
+		req := api.ChatCompletionRequest{}
+		json.NewDecoder(r.Body).Decode(&req)
+		requests = append(requests, req)
+
+		if !req.Stream {
+			json.NewEncoder(w).Encode(api.ChatCompletionResponse{
+				Choices: []api.ChatCompletionChoice{
+					{Message: &api.ChatMessage{Role: api.RoleAssistant, Content: testReply}},
+				},
+			})
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, word := range strings.SplitAfter(testReply, " ") {
+			chunk, _ := json.Marshal(api.ChatCompletionResponse{
+				Choices: []api.ChatCompletionChoice{{Delta: &api.ChatMessage{Content: word}}},
+			})
+			fmt.Fprintf(w, "data: %s\n\n", chunk)
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	t.Cleanup(server.Close)
+
+	viper.Set("llm.backend", BackendOpenAI)
+	viper.Set("llm.context", "You are a helpful assistant.")
+	viper.Set("llm.settings.maximum_prompt_tokens", 2048)
+	viper.Set("llm.openai", map[string]interface{}{
+		"base_url": server.URL + "/v1",
+		"api_key":  "secret",
+		"model":    "test-model",
+		"stream":   stream,
+	})
+	t.Cleanup(func() {
+		viper.Set("llm.backend", nil)
+		viper.Set("llm.context", nil)
+	})
+
+	return &requests
+}
+
+func TestRunInferenceOpenAI(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		name := "blocking"
+		if stream {
+			name = "stream"
+		}
+
+		t.Run(name, func(t *testing.T) {
+			requests := startFakeOpenAI(t, stream)
+
+			chatCtx := conversation("hello")
+			chatCtx.Messages = append(chatCtx.Messages, chat.ContextMessage{
+				Author:  chat.Author{Id: viper.GetString("llm.identifier_b")},
+				Message: "Hi!",
+			})
+
+			updates := []string{}
+			completed := ""
+
+			err := RunInference(
+				context.Background(),
+				t.Name(),
+				chatCtx,
+				nil,
+				func(output string) { updates = append(updates, output) },
+				func(output string) { completed = output },
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if completed != testReply {
+				t.Errorf("completed with %q, want %q", completed, testReply)
+			}
+
+			if len(updates) == 0 || updates[len(updates)-1] != testReply {
+				t.Errorf("unexpected updates %q", updates)
+			}
+
+			if len(*requests) != 1 {
+				t.Fatalf("got %d requests", len(*requests))
+			}
+
+			req := (*requests)[0]
+			roles := []api.ChatRole{}
+			for _, msg := range req.Messages {
+				roles = append(roles, msg.Role)
+			}
+
+			want := []api.ChatRole{api.RoleSystem, api.RoleUser, api.RoleAssistant}
+			if req.Model != "test-model" || fmt.Sprint(roles) != fmt.Sprint(want) {
+				t.Errorf("unexpected request %+v", req)
+			}
+		})
+	}
+}
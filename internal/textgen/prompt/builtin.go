@@ -0,0 +1,49 @@
+package prompt
+
+// builtins are the formats available without configuring them. The backend adds the
+// opening BOS token itself (llm.settings.add_bos_token), so only later ones are written.
+var builtins = map[string]Format{
+	// classic is the persona followed by a line per message, each starting with the
+	// speaker identifier
+	"classic": {
+		System:    "{{.System}}",
+		User:      RoleFormat{Prefix: "\n{{.User}} "},
+		Assistant: RoleFormat{Prefix: "\n{{.Bot}} "},
+		Primer:    "\n{{.Bot}}",
+	},
+	"alpaca": {
+		System:    "{{if .System}}{{.System}}\n\n{{end}}",
+		User:      RoleFormat{Prefix: "### Instruction:\n", Suffix: "\n\n"},
+		Assistant: RoleFormat{Prefix: "### Response:\n", Suffix: "\n\n"},
+		Primer:    "### Response:\n",
+		Stop:      []string{"### Instruction:", "### Response:"},
+	},
+	"vicuna": {
+		System:    "{{if .System}}{{.System}}\n\n{{end}}",
+		User:      RoleFormat{Prefix: "USER: ", Suffix: "\n"},
+		Assistant: RoleFormat{Prefix: "ASSISTANT: ", Suffix: "{{.EOS}}\n"},
+		Primer:    "ASSISTANT:",
+		BOS:       "<s>",
+		EOS:       "</s>",
+		Stop:      []string{"\nUSER:", "</s>"},
+	},
+	"chatml": {
+		System:    "{{if .System}}<|im_start|>system\n{{.System}}<|im_end|>\n{{end}}",
+		User:      RoleFormat{Prefix: "<|im_start|>user\n", Suffix: "<|im_end|>\n"},
+		Assistant: RoleFormat{Prefix: "<|im_start|>assistant\n", Suffix: "<|im_end|>\n"},
+		Primer:    "<|im_start|>assistant\n",
+		EOS:       "<|im_end|>",
+		Stop:      []string{"<|im_end|>", "<|im_start|>"},
+	},
+	// llama-2 puts the system block inside the first instruction, so the first user
+	// message continues the [INST] the system block opens. When the assistant speaks
+	// first, as with a greeting, the instruction is closed empty before it.
+	"llama-2": {
+		System:    "[INST] {{if .System}}<<SYS>>\n{{.System}}\n<</SYS>>\n\n{{end}}",
+		User:      RoleFormat{Prefix: "{{if not .First}}{{.BOS}}[INST] {{end}}", Suffix: " [/INST]"},
+		Assistant: RoleFormat{Prefix: "{{if .First}} [/INST]{{end}} ", Suffix: " {{.EOS}}"},
+		BOS:       "<s>",
+		EOS:       "</s>",
+		Stop:      []string{"[INST]", "</s>"},
+	},
+}
@@ -0,0 +1,240 @@
+// Package prompt lays conversations out in the format a model was trained on.
+package prompt
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/M-Ro/aurora-ai/internal/textgen/character"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+var (
+	ErrUnknownFormat = errors.New("Unknown prompt format")
+)
+
+// DefaultFormat is the format used when none is configured.
+const DefaultFormat = "classic"
+
+// Role is who a message in the prompt is from.
+type Role string
+
+const (
+	RoleUser      Role = "user"
+	RoleAssistant Role = "assistant"
+)
+
+// RoleFormat wraps each message of a role.
+type RoleFormat struct {
+	Prefix string `mapstructure:"prefix"`
+	Suffix string `mapstructure:"suffix"`
+}
+
+// Format is a prompt format, configured under llm.prompt_formats.<name>. Every part is a
+// text/template executed with Data, apart from the messages themselves, which are
+// inserted as is.
+type Format struct {
+	// System is the block opening the prompt, holding the persona
+	System    string     `mapstructure:"system"`
+	User      RoleFormat `mapstructure:"user"`
+	Assistant RoleFormat `mapstructure:"assistant"`
+	// Primer follows the conversation to prompt the assistant's reply
+	Primer string `mapstructure:"primer"`
+	BOS    string `mapstructure:"bos"`
+	EOS    string `mapstructure:"eos"`
+	// Stop holds strings ending the assistant's turn, on top of llm.settings.stopping_strings
+	Stop []string `mapstructure:"stop"`
+
+	templates *template.Template
+	// showsSpeaker is set when the user prefix has the speaker in it
+	showsSpeaker bool
+}
+
+// Data is what a format's templates are executed with.
+type Data struct {
+	// System is the persona, from the character card or llm.context, along with what is
+	// remembered of the conversation
+	System string
+	// User and Bot are the speaker identifiers, from llm.identifier_p & the character card
+	// or llm.identifier_b. When messages are attributed to their authors, User is the
+	// author's name
+	User string
+	Bot  string
+	// Name is the display name of a message's author
+	Name string
+	// First is set for the first message of the conversation
+	First bool
+	BOS   string
+	EOS   string
+}
+
+// compile parses the format's templates, checking they execute.
+func (f *Format) compile(name string) error {
+	parts := map[string]string{
+		"system":           f.System,
+		"user.prefix":      f.User.Prefix,
+		"user.suffix":      f.User.Suffix,
+		"assistant.prefix": f.Assistant.Prefix,
+		"assistant.suffix": f.Assistant.Suffix,
+		"primer":           f.Primer,
+	}
+
+	f.templates = template.New(name)
+	for part, text := range parts {
+		_, err := f.templates.New(part).Parse(text)
+		if err != nil {
+			return fmt.Errorf("Prompt format %s: %w", name, err)
+		}
+	}
+
+	for part := range parts {
+		err := f.templates.ExecuteTemplate(&strings.Builder{}, part, Data{})
+		if err != nil {
+			return fmt.Errorf("Prompt format %s: %w", name, err)
+		}
+	}
+
+	f.showsSpeaker = strings.Contains(f.User.Prefix, ".User") || strings.Contains(f.User.Prefix, ".Name")
+
+	return nil
+}
+
+func (f *Format) execute(part string, data Data) string {
+	data.BOS = f.BOS
+	data.EOS = f.EOS
+	if data.System == "" {
+		data.System = character.Persona()
+	}
+	data.Bot = character.BotToken()
+	if data.User == "" {
+		data.User = viper.GetString("llm.identifier_p")
+	}
+
+	b := strings.Builder{}
+	err := f.templates.ExecuteTemplate(&b, part, data)
+	if err != nil {
+		logrus.Errorf("Prompt format %s: %v", f.templates.Name(), err)
+	}
+
+	return b.String()
+}
+
+// SystemBlock returns the opening of the prompt, holding system in place of the persona
+// if set.
+func (f *Format) SystemBlock(system string) string {
+	return f.execute("system", Data{System: system})
+}
+
+// Message returns a message of the conversation, wrapped as its role's. A user's message
+// is labelled with speaker in place of the user identifier if set, and where the
+// format has no place for it, the message starts with it instead.
+func (f *Format) Message(role Role, name string, speaker string, text string, first bool) string {
+	return f.message(role, name, speaker, text, first, false)
+}
+
+// OpenMessage returns a message like Message, but left open without its suffix so the
+// model carries on with it in place of the primer.
+func (f *Format) OpenMessage(role Role, name string, speaker string, text string, first bool) string {
+	return f.message(role, name, speaker, text, first, true)
+}
+
+func (f *Format) message(role Role, name string, speaker string, text string, first bool, open bool) string {
+	data := Data{Name: name, First: first}
+
+	if role == RoleUser && speaker != "" {
+		data.User = speaker
+		if !f.showsSpeaker {
+			text = speaker + " " + text
+		}
+	}
+
+	text = f.execute(string(role)+".prefix", data) + text
+	if open {
+		return text
+	}
+
+	return text + f.execute(string(role)+".suffix", data)
+}
+
+// PrimerBlock returns what follows the conversation, prompting the assistant's reply.
+func (f *Format) PrimerBlock() string {
+	return f.execute("primer", Data{})
+}
+
+type loadedFormat struct {
+	format    *Format
+	signature string
+}
+
+var (
+	formatsMu sync.Mutex
+	formats   = map[string]*loadedFormat{}
+)
+
+// Get returns the format called name, from llm.prompt_formats or else the built-ins.
+func Get(name string) (*Format, error) {
+	if name == "" {
+		name = DefaultFormat
+	}
+
+	format := Format{}
+	key := "llm.prompt_formats." + name
+	if viper.IsSet(key) {
+		err := viper.UnmarshalKey(key, &format)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		builtin, ok := builtins[name]
+		if !ok {
+			return nil, fmt.Errorf("%w %q", ErrUnknownFormat, name)
+		}
+		format = builtin
+	}
+
+	signature := fmt.Sprintf("%#v", format)
+
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+
+	loaded, ok := formats[name]
+	if ok && loaded.signature == signature {
+		return loaded.format, nil
+	}
+
+	err := format.compile(name)
+	if err != nil {
+		return nil, err
+	}
+	formats[name] = &loadedFormat{format: &format, signature: signature}
+
+	return &format, nil
+}
+
+// ForKey returns the format used by the generator configured at key, going by
+// <key>.prompt_format and else llm.prompt_format.
+func ForKey(key string) (*Format, error) {
+	name := viper.GetString(key + ".prompt_format")
+	if name == "" {
+		name = viper.GetString("llm.prompt_format")
+	}
+
+	return Get(name)
+}
+
+// Default returns the format of llm.prompt_format, falling back to the classic one
+// should it be broken.
+func Default() *Format {
+	format, err := ForKey("llm")
+	if err != nil {
+		logrus.Error(err)
+
+		format, _ = Get(DefaultFormat)
+	}
+
+	return format
+}
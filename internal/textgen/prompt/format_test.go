@@ -0,0 +1,45 @@
+package prompt
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestConfiguredFormat(t *testing.T) {
+	viper.Set("llm.context", "Be nice.")
+	viper.Set("llm.prompt_formats.custom", map[string]interface{}{
+		"system": "{{.BOS}}{{.System}}\n",
+		"user":   map[string]interface{}{"prefix": "{{.Name}}: ", "suffix": "\n"},
+		"primer": "bot:",
+		"bos":    "<s>",
+	})
+	viper.Set("llm.generators.custom.prompt_format", "custom")
+	defer viper.Set("llm.prompt_formats", map[string]interface{}{})
+
+	format, err := ForKey("llm.generators.custom")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := format.SystemBlock("") + format.Message(RoleUser, "alice", "", "hi", true) + format.PrimerBlock()
+	want := "<s>Be nice.\nalice: hi\nbot:"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestBrokenFormat(t *testing.T) {
+	viper.Set("llm.prompt_formats.broken", map[string]interface{}{"system": "{{.Missing}}"})
+	defer viper.Set("llm.prompt_formats", map[string]interface{}{})
+
+	_, err := Get("broken")
+	if err == nil {
+		t.Error("Expected an error from a template using a missing field")
+	}
+
+	_, err = Get("nonexistent")
+	if err == nil {
+		t.Error("Expected an error from an unknown format")
+	}
+}
@@ -0,0 +1,160 @@
+package recall
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/M-Ro/aurora-ai/api"
+)
+
+// Embedder maps text to a vector, which lies closer to those of texts alike in meaning.
+type Embedder interface {
+	Embed(text string) ([]float32, error)
+	// Name identifies the embedding model, as vectors from different ones don't compare
+	Name() string
+}
+
+// hashDimensions is the length of the vectors of the hashing embedder.
+const hashDimensions = 512
+
+// Hashing embeds text locally by hashing its words & word pairs into a fixed number of
+// buckets. It only matches texts sharing words, but needs no model to run.
+type Hashing struct{}
+
+func (Hashing) Name() string {
+	return fmt.Sprintf("hashing-%d", hashDimensions)
+}
+
+func (Hashing) Embed(text string) ([]float32, error) {
+	vector := make([]float32, hashDimensions)
+
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+
+	for i, word := range words {
+		addFeature(vector, word, 1)
+		if i > 0 {
+			addFeature(vector, words[i-1]+" "+word, 0.5)
+		}
+	}
+
+	// Dampen words repeated over & over
+	for i, v := range vector {
+		if v > 0 {
+			vector[i] = float32(1 + math.Log(float64(v)))
+		} else if v < 0 {
+			vector[i] = -float32(1 + math.Log(float64(-v)))
+		}
+	}
+
+	return normalize(vector), nil
+}
+
+// addFeature adds weight to the bucket the feature hashes to, with the sign taken from
+// the hash as well so collisions tend to cancel out.
+func addFeature(vector []float32, feature string, weight float32) {
+	h := fnv.New64a()
+	h.Write([]byte(feature))
+	sum := h.Sum64()
+
+	if sum&(1<<63) != 0 {
+		weight = -weight
+	}
+
+	vector[sum%uint64(len(vector))] += weight
+}
+
+// normalize scales vector to unit length, so the dot product of two is their cosine.
+func normalize(vector []float32) []float32 {
+	norm := 0.0
+	for _, v := range vector {
+		norm += float64(v) * float64(v)
+	}
+
+	if norm == 0 {
+		return vector
+	}
+
+	scale := float32(1 / math.Sqrt(norm))
+	for i := range vector {
+		vector[i] *= scale
+	}
+
+	return vector
+}
+
+const remoteTimeout = 30 * time.Second
+
+// Remote embeds text on an OpenAI style /v1/embeddings endpoint, as served by OpenAI,
+// llama.cpp server, text-generation-webui & vLLM among others.
+type Remote struct {
+	// URL of the endpoint, e.g http://127.0.0.1:8080/v1/embeddings
+	URL   string
+	Model string
+
+	client http.Client
+}
+
+func NewRemote(url string, model string) *Remote {
+	if !strings.Contains(url, "://") {
+		url = "http://" + url
+	}
+
+	return &Remote{
+		URL:    url,
+		Model:  model,
+		client: http.Client{Timeout: remoteTimeout},
+	}
+}
+
+func (r *Remote) Name() string {
+	if r.Model == "" {
+		return r.URL
+	}
+
+	return r.Model
+}
+
+func (r *Remote) Embed(text string) ([]float32, error) {
+	data, err := json.Marshal(&api.EmbeddingRequest{Model: r.Model, Input: []string{text}})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, r.URL, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Embedding request to %s failed: %s", r.URL, res.Status)
+	}
+
+	response := api.EmbeddingResponse{}
+	err = json.NewDecoder(res.Body).Decode(&response)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(response.Data) == 0 || len(response.Data[0].Embedding) == 0 {
+		return nil, fmt.Errorf("Embedding response from %s has no embedding", r.URL)
+	}
+
+	return normalize(response.Data[0].Embedding), nil
+}
@@ -0,0 +1,397 @@
+package recall
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/M-Ro/aurora-ai/internal/textgen/context"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	ErrNotAnIndex = errors.New("File is not a recall index")
+	ErrBadRecord  = errors.New("Malformed index record")
+)
+
+// indexMagic opens every index file, followed by its version.
+const (
+	indexMagic   = "ARCL"
+	indexVersion = 1
+)
+
+// maxRecordSize bounds records read, so a damaged length can't exhaust memory.
+const maxRecordSize = 16 << 20
+
+// Entry is a message kept in the index.
+type Entry struct {
+	// Id of the message in the chat; a message stored again under the same id replaces
+	// the one before
+	Id     string
+	Author context.Author
+	Text   string
+	Vector []float32
+}
+
+// Hit is an entry matching a search, with how close it is to the query from -1 to 1.
+type Hit struct {
+	Position int
+	Score    float32
+}
+
+// Index keeps the messages of a channel with their embeddings in a file, searched by
+// comparing the query against each of them. The file is a header naming the embedder
+// followed by a record per message stored, which is only ever appended to; it's
+// rewritten without the replaced records once they make up most of it.
+type Index struct {
+	path     string
+	embedder string
+
+	entries   []Entry
+	positions map[string]int
+	// records counts those in the file, including replaced ones
+	records int
+
+	mu sync.Mutex
+}
+
+// OpenIndex opens the index at path, creating it for vectors of the embedder if it
+// doesn't exist yet. A record cut short, as by a crash while it was written, is
+// dropped.
+func OpenIndex(path string, embedder string) (*Index, error) {
+	idx := &Index{
+		path:      path,
+		embedder:  embedder,
+		positions: map[string]int{},
+	}
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return idx, idx.rewrite()
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+
+	idx.embedder, err = readHeader(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	offset := headerSize(idx.embedder)
+	for {
+		entry, size, err := readRecord(reader)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			logrus.Warnf("Dropping what follows the last whole record of %s: %v", path, err)
+			return idx, os.Truncate(path, offset)
+		}
+
+		idx.put(entry)
+		idx.records++
+		offset += size
+	}
+
+	return idx, nil
+}
+
+// Embedder returns the name of the embedder the index's vectors come from.
+func (idx *Index) Embedder() string {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	return idx.embedder
+}
+
+// Len returns the number of messages in the index.
+func (idx *Index) Len() int {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	return len(idx.entries)
+}
+
+// Entry returns the message at position i, messages being in the order first stored.
+func (idx *Index) Entry(i int) Entry {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	return idx.entries[i]
+}
+
+// Has reports whether the message with the id is stored with the text.
+func (idx *Index) Has(id string, text string) bool {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	i, ok := idx.positions[id]
+
+	return ok && idx.entries[i].Text == text
+}
+
+// Add stores the message, replacing the one stored under its id before.
+func (idx *Index) Add(entry Entry) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	file, err := os.OpenFile(idx.path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(encodeRecord(entry))
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	err = file.Close()
+	if err != nil {
+		return err
+	}
+
+	idx.put(entry)
+	idx.records++
+
+	if idx.records > 2*len(idx.entries)+64 {
+		return idx.rewrite()
+	}
+
+	return nil
+}
+
+// Search returns the k messages closest to the vector, closest first. Messages skip
+// returns true for aren't considered.
+func (idx *Index) Search(vector []float32, k int, skip func(Entry) bool) []Hit {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	hits := []Hit{}
+	for i, entry := range idx.entries {
+		if len(entry.Vector) != len(vector) || (skip != nil && skip(entry)) {
+			continue
+		}
+
+		hits = append(hits, Hit{Position: i, Score: dot(entry.Vector, vector)})
+	}
+
+	sort.SliceStable(hits, func(a, b int) bool {
+		return hits[a].Score > hits[b].Score
+	})
+
+	if len(hits) > k {
+		hits = hits[:k]
+	}
+
+	return hits
+}
+
+// Reembed replaces the vectors of every message with the embedder's, for the index to
+// be searched with it.
+func (idx *Index) Reembed(embedder Embedder) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	for i := range idx.entries {
+		vector, err := embedder.Embed(idx.entries[i].Text)
+		if err != nil {
+			return err
+		}
+
+		idx.entries[i].Vector = vector
+	}
+
+	idx.embedder = embedder.Name()
+
+	return idx.rewrite()
+}
+
+// Clear drops every message from the index.
+func (idx *Index) Clear() error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.entries = nil
+	idx.positions = map[string]int{}
+
+	return idx.rewrite()
+}
+
+// put stores the entry in memory, in place of the one with its id if there is one.
+func (idx *Index) put(entry Entry) {
+	if i, ok := idx.positions[entry.Id]; ok {
+		idx.entries[i] = entry
+		return
+	}
+
+	if entry.Id != "" {
+		idx.positions[entry.Id] = len(idx.entries)
+	}
+	idx.entries = append(idx.entries, entry)
+}
+
+// rewrite writes the index anew with only the records of the messages it holds,
+// replacing the file once it's complete.
+func (idx *Index) rewrite() error {
+	buf := bytes.Buffer{}
+	buf.Write(encodeHeader(idx.embedder))
+	for _, entry := range idx.entries {
+		buf.Write(encodeRecord(entry))
+	}
+
+	tmp := idx.path + ".tmp"
+	err := ioutil.WriteFile(tmp, buf.Bytes(), 0644)
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmp, idx.path)
+	if err != nil {
+		return err
+	}
+
+	idx.records = len(idx.entries)
+
+	return nil
+}
+
+func dot(a []float32, b []float32) float32 {
+	sum := float32(0)
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+
+	return sum
+}
+
+func encodeHeader(embedder string) []byte {
+	buf := append([]byte(indexMagic), indexVersion)
+
+	return appendString(buf, embedder)
+}
+
+func headerSize(embedder string) int64 {
+	return int64(len(encodeHeader(embedder)))
+}
+
+func readHeader(reader *bufio.Reader) (string, error) {
+	magic := make([]byte, len(indexMagic)+1)
+	_, err := io.ReadFull(reader, magic)
+	if err != nil || string(magic[:len(indexMagic)]) != indexMagic || magic[len(indexMagic)] != indexVersion {
+		return "", ErrNotAnIndex
+	}
+
+	embedder, err := readString(reader, 1024)
+	if err != nil {
+		return "", ErrNotAnIndex
+	}
+
+	return embedder, nil
+}
+
+// encodeRecord lays out the entry as a record: its length followed by the id, author
+// id & name and text, each prefixed by their length, then the vector's length and
+// values.
+func encodeRecord(entry Entry) []byte {
+	payload := []byte{}
+	payload = appendString(payload, entry.Id)
+	payload = appendString(payload, entry.Author.Id)
+	payload = appendString(payload, entry.Author.Name)
+	payload = appendString(payload, entry.Text)
+	payload = appendUvarint(payload, uint64(len(entry.Vector)))
+
+	value := make([]byte, 4)
+	for _, v := range entry.Vector {
+		binary.LittleEndian.PutUint32(value, math.Float32bits(v))
+		payload = append(payload, value...)
+	}
+
+	return append(appendUvarint(nil, uint64(len(payload))), payload...)
+}
+
+// readRecord reads the next record, returning it with the bytes it took up. io.EOF is
+// returned at the end of the file only where a record would start.
+func readRecord(reader *bufio.Reader) (Entry, int64, error) {
+	length, err := binary.ReadUvarint(reader)
+	if err == io.EOF {
+		return Entry{}, 0, io.EOF
+	}
+	if err != nil || length > maxRecordSize {
+		return Entry{}, 0, ErrBadRecord
+	}
+
+	payload := make([]byte, length)
+	_, err = io.ReadFull(reader, payload)
+	if err != nil {
+		return Entry{}, 0, ErrBadRecord
+	}
+
+	size := int64(len(appendUvarint(nil, length))) + int64(length)
+
+	record := bytes.NewReader(payload)
+	entry := Entry{}
+	fields := []*string{&entry.Id, &entry.Author.Id, &entry.Author.Name, &entry.Text}
+	for _, field := range fields {
+		*field, err = readString(record, record.Len())
+		if err != nil {
+			return Entry{}, 0, ErrBadRecord
+		}
+	}
+
+	dimensions, err := binary.ReadUvarint(record)
+	if err != nil || dimensions*4 != uint64(record.Len()) {
+		return Entry{}, 0, ErrBadRecord
+	}
+
+	entry.Vector = make([]float32, dimensions)
+	value := make([]byte, 4)
+	for i := range entry.Vector {
+		record.Read(value)
+		entry.Vector[i] = math.Float32frombits(binary.LittleEndian.Uint32(value))
+	}
+
+	return entry, size, nil
+}
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	tmp := make([]byte, binary.MaxVarintLen64)
+
+	return append(buf, tmp[:binary.PutUvarint(tmp, v)]...)
+}
+
+func appendString(buf []byte, s string) []byte {
+	return append(appendUvarint(buf, uint64(len(s))), s...)
+}
+
+// readString reads a string prefixed by its length, which may be at most max.
+func readString(reader io.ByteReader, max int) (string, error) {
+	length, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return "", err
+	}
+	if length > uint64(max) {
+		return "", ErrBadRecord
+	}
+
+	buf := make([]byte, length)
+	for i := range buf {
+		buf[i], err = reader.ReadByte()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return string(buf), nil
+}
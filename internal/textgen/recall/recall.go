@@ -0,0 +1,371 @@
+// Package recall is the long-term memory of each channel: every message is kept with
+// its embedding in an index on disk, and the past exchanges closest to what was just
+// said are recalled into the prompt.
+package recall
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/M-Ro/aurora-ai/internal/textgen/character"
+	"github.com/M-Ro/aurora-ai/internal/textgen/context"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// Settings are read from llm.recall, besides enabled & max_tokens which the context
+// reads to keep room for what is recalled.
+type Settings struct {
+	// Dir holds an index file per channel
+	Dir string `mapstructure:"dir"`
+	// TopK is the most exchanges recalled for a reply
+	TopK int `mapstructure:"top_k"`
+	// MinScore is how close an exchange must be to what was said to be recalled
+	MinScore  float32 `mapstructure:"min_score"`
+	Embedding struct {
+		// Endpoint is an OpenAI style /v1/embeddings; text is embedded locally by
+		// hashing its words when it isn't set
+		Endpoint string `mapstructure:"endpoint"`
+		Model    string `mapstructure:"model"`
+	} `mapstructure:"embedding"`
+}
+
+// Recollection is a past exchange recalled, or found by Search.
+type Recollection struct {
+	// First & Last are the positions of the exchange's messages in the index
+	First int
+	Last  int
+	Score float32
+	Text  string
+}
+
+var (
+	mu        sync.Mutex
+	signature string
+	embedder  Embedder
+	indexes   = map[string]*channelIndex{}
+	// recalled holds the exchanges last recalled in each channel, to be inspected
+	recalled = map[string][]Recollection{}
+)
+
+// channelIndex is the index of a channel, opened on first use. It has a lock of its
+// own, so opening it, which may mean embedding every message again, doesn't hold up
+// the other channels.
+type channelIndex struct {
+	mu sync.Mutex
+	// idx is nil until opened, one which failed to open is tried again on next use
+	idx *Index
+}
+
+// Remember stores the message in the channel's index, embedding it. A message already
+// stored is stored again only if its text changed, as when a reply is regenerated.
+func Remember(channelID string, ctxMsg context.ContextMessage) error {
+	if !context.Recalls() || ctxMsg.Id == "" || strings.TrimSpace(ctxMsg.Message) == "" {
+		return nil
+	}
+
+	idx, embedder, _, err := open(channelID)
+	if err != nil {
+		return err
+	}
+
+	if idx.Has(ctxMsg.Id, ctxMsg.Message) {
+		return nil
+	}
+
+	vector, err := embedder.Embed(ctxMsg.Message)
+	if err != nil {
+		return err
+	}
+
+	return idx.Add(Entry{
+		Id:     ctxMsg.Id,
+		Author: ctxMsg.Author,
+		Text:   ctxMsg.Message,
+		Vector: vector,
+	})
+}
+
+// Recall sets the conversation's recalled exchanges to those of the channel's past
+// closest to its last message, as many as fit in the recall budget. Exchanges still in
+// the conversation aren't recalled. The conversation is searched from a snapshot, so it
+// is only locked while it's read and updated.
+func Recall(channelID string, chatCtx *context.ChatContext) ([]Recollection, error) {
+	chatCtx.Lock()
+	chatCtx.Recalled = nil
+	snapshot := chatCtx.Snapshot()
+	chatCtx.Unlock()
+
+	if !context.Recalls() || len(snapshot.Messages) == 0 {
+		return nil, nil
+	}
+
+	candidates, err := search(channelID, snapshot.Messages[len(snapshot.Messages)-1].Message, snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	// The closest first, in the order they happened in the prompt
+	chosen := []Recollection{}
+	for _, candidate := range candidates {
+		attempt := append(append([]Recollection{}, chosen...), candidate)
+		sort.Slice(attempt, func(a, b int) bool {
+			return attempt[a].First < attempt[b].First
+		})
+
+		snapshot.Recalled = texts(attempt)
+		if snapshot.RecallFits() {
+			chosen = attempt
+		}
+	}
+
+	chatCtx.Lock()
+	chatCtx.Recalled = texts(chosen)
+	chatCtx.Unlock()
+
+	mu.Lock()
+	recalled[channelID] = chosen
+	mu.Unlock()
+
+	if len(chosen) > 0 {
+		logrus.Debugf("Recalled %d of %d exchanges for %s: %s", len(chosen), len(candidates), channelID, describe(chosen))
+	}
+
+	return chosen, nil
+}
+
+// Recalled returns the exchanges last recalled in the channel.
+func Recalled(channelID string) []Recollection {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return recalled[channelID]
+}
+
+// Search returns the channel's past exchanges closest to the query, closest first,
+// regardless of the budget.
+func Search(channelID string, query string) ([]Recollection, error) {
+	chatCtx := context.GetContext(channelID)
+	chatCtx.Lock()
+	snapshot := chatCtx.Snapshot()
+	chatCtx.Unlock()
+
+	return search(channelID, query, snapshot)
+}
+
+// Stats returns how many messages the channel's index holds & the embedder of their
+// vectors.
+func Stats(channelID string) (int, string, error) {
+	idx, _, _, err := open(channelID)
+	if err != nil {
+		return 0, "", err
+	}
+
+	return idx.Len(), idx.Embedder(), nil
+}
+
+// Forget drops everything stored for the channel, with recall turned off too so none
+// of it is recalled once it's back on. The index file is removed without being opened,
+// so one which can't be read is dropped all the same.
+func Forget(channelID string) error {
+	settings := loadSettings()
+
+	mu.Lock()
+	delete(recalled, channelID)
+	channel := indexes[channelID]
+	delete(indexes, channelID)
+	mu.Unlock()
+
+	// An index being opened is waited for, lest it's written after being removed
+	if channel != nil {
+		channel.mu.Lock()
+		defer channel.mu.Unlock()
+	}
+
+	err := os.Remove(filepath.Join(settings.Dir, fileName(channelID)))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// search returns up to top_k exchanges around the messages closest to the query, which
+// are related at all, at least min_score close and have no message in the conversation.
+func search(channelID string, query string, chatCtx *context.ChatContext) ([]Recollection, error) {
+	idx, embedder, settings, err := open(channelID)
+	if err != nil {
+		return nil, err
+	}
+
+	vector, err := embedder.Embed(query)
+	if err != nil {
+		return nil, err
+	}
+
+	current := map[string]bool{}
+	for _, ctxMsg := range chatCtx.Messages {
+		current[ctxMsg.Id] = true
+	}
+	inConversation := func(entry Entry) bool {
+		return current[entry.Id]
+	}
+
+	// Neighbouring messages make up the same exchange, so look further than top_k
+	hits := idx.Search(vector, settings.TopK*2, inConversation)
+
+	recollections := []Recollection{}
+	covered := map[int]bool{}
+	for _, hit := range hits {
+		if hit.Score <= 0 || hit.Score < settings.MinScore || covered[hit.Position] || len(recollections) >= settings.TopK {
+			continue
+		}
+
+		first, last := exchange(idx, hit.Position, inConversation)
+		messages := []context.ContextMessage{}
+		for i := first; i <= last; i++ {
+			covered[i] = true
+
+			entry := idx.Entry(i)
+			messages = append(messages, context.ContextMessage{Id: entry.Id, Author: entry.Author, Message: entry.Text})
+		}
+
+		recollections = append(recollections, Recollection{
+			First: first,
+			Last:  last,
+			Score: hit.Score,
+			Text:  chatCtx.Transcript(messages),
+		})
+	}
+
+	return recollections, nil
+}
+
+// exchange returns the positions of the exchange the message at i is part of: a
+// message & the bot's reply to it.
+func exchange(idx *Index, i int, skip func(Entry) bool) (int, int) {
+	botToken := character.BotToken()
+
+	if idx.Entry(i).Author.Id != botToken {
+		if i+1 < idx.Len() && idx.Entry(i+1).Author.Id == botToken && !skip(idx.Entry(i+1)) {
+			return i, i + 1
+		}
+
+		return i, i
+	}
+
+	if i > 0 && idx.Entry(i-1).Author.Id != botToken && !skip(idx.Entry(i-1)) {
+		return i - 1, i
+	}
+
+	return i, i
+}
+
+// loadSettings reads the settings from llm.recall, defaulting those not set.
+func loadSettings() Settings {
+	settings := Settings{}
+	err := viper.UnmarshalKey("llm.recall", &settings)
+	if err != nil {
+		logrus.Error(err)
+	}
+
+	if settings.Dir == "" {
+		settings.Dir = "memory"
+	}
+
+	if settings.TopK <= 0 {
+		settings.TopK = 4
+	}
+
+	return settings
+}
+
+// open returns the channel's index, with the embedder to search it with. The package's
+// lock is only held to look the index up, it's opened under its own.
+func open(channelID string) (*Index, Embedder, Settings, error) {
+	settings := loadSettings()
+
+	mu.Lock()
+	s := settings.Dir + " " + settings.Embedding.Endpoint + " " + settings.Embedding.Model
+	if s != signature {
+		signature = s
+		indexes = map[string]*channelIndex{}
+
+		embedder = Hashing{}
+		if settings.Embedding.Endpoint != "" {
+			embedder = NewRemote(settings.Embedding.Endpoint, settings.Embedding.Model)
+		}
+
+		logrus.Infof("Recalling from %s with embeddings from %s", settings.Dir, embedder.Name())
+	}
+
+	channel, ok := indexes[channelID]
+	if !ok {
+		channel = &channelIndex{}
+		indexes[channelID] = channel
+	}
+	current := embedder
+	mu.Unlock()
+
+	channel.mu.Lock()
+	defer channel.mu.Unlock()
+
+	if channel.idx != nil {
+		return channel.idx, current, settings, nil
+	}
+
+	err := os.MkdirAll(settings.Dir, 0755)
+	if err != nil {
+		return nil, nil, settings, err
+	}
+
+	path := filepath.Join(settings.Dir, fileName(channelID))
+	idx, err := OpenIndex(path, current.Name())
+	if err != nil {
+		return nil, nil, settings, fmt.Errorf("Opening %s failed: %w", path, err)
+	}
+
+	if idx.Embedder() != current.Name() {
+		logrus.Infof("Embedding the %d messages of %s again with %s", idx.Len(), path, current.Name())
+
+		err = idx.Reembed(current)
+		if err != nil {
+			return nil, nil, settings, err
+		}
+	}
+
+	channel.idx = idx
+
+	return idx, current, settings, nil
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+// fileName returns the name of the channel's index file.
+func fileName(channelID string) string {
+	return unsafeFileChars.ReplaceAllString(channelID, "_") + ".idx"
+}
+
+func texts(recollections []Recollection) []string {
+	texts := []string{}
+	for _, recollection := range recollections {
+		texts = append(texts, recollection.Text)
+	}
+
+	return texts
+}
+
+// describe lists the recollections' positions & scores for the log.
+func describe(recollections []Recollection) string {
+	parts := []string{}
+	for _, recollection := range recollections {
+		parts = append(parts, fmt.Sprintf("#%d-%d (%.2f)", recollection.First, recollection.Last, recollection.Score))
+	}
+
+	return strings.Join(parts, ", ")
+}
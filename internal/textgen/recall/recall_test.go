@@ -0,0 +1,251 @@
+package recall
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/M-Ro/aurora-ai/api"
+	"github.com/M-Ro/aurora-ai/internal/textgen/context"
+	"github.com/spf13/viper"
+)
+
+func TestIndexReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "1.idx")
+
+	idx, err := OpenIndex(path, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, entry := range []Entry{
+		{Id: "1", Author: context.Author{Id: "a", Name: "alice"}, Text: "one", Vector: []float32{1, 0}},
+		{Id: "2", Author: context.Author{Id: "b", Name: "bob"}, Text: "two", Vector: []float32{0, 1}},
+		{Id: "1", Author: context.Author{Id: "a", Name: "alice"}, Text: "uno", Vector: []float32{0.6, 0.8}},
+	} {
+		err = idx.Add(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A record cut short is dropped
+	file, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	file.Write([]byte{40, 1, 'x'})
+	file.Close()
+
+	idx, err = OpenIndex(path, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if idx.Len() != 2 || idx.Entry(0).Text != "uno" || idx.Entry(1).Author.Name != "bob" {
+		t.Fatalf("got %d entries, first %+v", idx.Len(), idx.Entry(0))
+	}
+
+	hits := idx.Search([]float32{1, 0}, 1, nil)
+	if len(hits) != 1 || hits[0].Position != 0 || hits[0].Score < 0.59 || hits[0].Score > 0.61 {
+		t.Errorf("got hits %+v", hits)
+	}
+
+	err = idx.Add(Entry{Id: "3", Text: "three", Vector: []float32{1, 0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idx, err = OpenIndex(path, "test")
+	if err != nil || idx.Len() != 3 {
+		t.Errorf("got %v after adding past a dropped record", err)
+	}
+}
+
+func TestHashing(t *testing.T) {
+	embed := func(text string) []float32 {
+		vector, _ := Hashing{}.Embed(text)
+		return vector
+	}
+
+	query := embed("What was my cat called again?")
+	related := dot(query, embed("My cat is called Tom."))
+	unrelated := dot(query, embed("The weather is lovely today."))
+
+	if related <= unrelated {
+		t.Errorf("related text scored %.2f, unrelated %.2f", related, unrelated)
+	}
+}
+
+func TestRecall(t *testing.T) {
+	viper.Set("llm.context", "A chat.")
+	viper.Set("llm.identifier_p", "### Human:")
+	viper.Set("llm.identifier_b", "### Assistant:")
+	viper.Set("llm.settings.maximum_prompt_tokens", 2048)
+	viper.Set("llm.recall.enabled", true)
+	viper.Set("llm.recall.dir", t.TempDir())
+	viper.Set("llm.recall.max_tokens", 64)
+	defer viper.Set("llm.recall.enabled", false)
+
+	said := func(id string, text string) context.ContextMessage {
+		return context.ContextMessage{Id: id, Author: context.Author{Id: "1", Name: "alice"}, Message: text}
+	}
+	replied := func(id string, text string) context.ContextMessage {
+		ctxMsg := context.NewCtxMsgFromBotResponse(text)
+		ctxMsg.Id = id
+		return ctxMsg
+	}
+
+	for _, ctxMsg := range []context.ContextMessage{
+		said("1", "My cat is called Tom."),
+		replied("2", "Tom is a fine name for a cat."),
+		said("3", "The weather is lovely today."),
+		replied("4", "Perfect for a walk."),
+	} {
+		err := Remember("channel", ctxMsg)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	chatCtx := &context.ChatContext{}
+	chatCtx.AddMessage(&context.ContextMessage{Id: "4", Author: context.Author{Id: "### Assistant:"}, Message: "Perfect for a walk."})
+	chatCtx.AddMessage(&context.ContextMessage{Id: "5", Author: context.Author{Id: "1", Name: "alice"}, Message: "What was my cat called again?"})
+
+	recollections, err := Recall("channel", chatCtx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(recollections) != 1 || recollections[0].First != 0 || recollections[0].Last != 1 {
+		t.Fatalf("got recollections %+v", recollections)
+	}
+
+	want := "Earlier in the conversation:\nalice: My cat is called Tom.\nAssistant: Tom is a fine name for a cat."
+	if !strings.Contains(chatCtx.Prompt(), want) {
+		t.Errorf("recalled exchange missing from prompt %q", chatCtx.Prompt())
+	}
+
+	// Nothing is recalled over the budget
+	viper.Set("llm.recall.max_tokens", 8)
+	recollections, _ = Recall("channel", chatCtx)
+	if len(recollections) != 0 || len(chatCtx.Recalled) != 0 {
+		t.Errorf("recalled %+v over the budget", recollections)
+	}
+}
+
+func TestForget(t *testing.T) {
+	viper.Set("llm.recall.enabled", true)
+	viper.Set("llm.recall.dir", t.TempDir())
+	viper.Set("llm.recall.max_tokens", 64)
+	defer viper.Set("llm.recall.enabled", false)
+
+	err := Remember("reset", context.ContextMessage{Id: "1", Author: context.Author{Id: "1", Name: "alice"}, Message: "My cat is called Tom."})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chatCtx := &context.ChatContext{}
+	chatCtx.AddMessage(&context.ContextMessage{Id: "2", Author: context.Author{Id: "1", Name: "alice"}, Message: "What was my cat called?"})
+	recollections, err := Recall("reset", chatCtx)
+	if err != nil || len(recollections) != 1 {
+		t.Fatalf("got recollections %+v, %v", recollections, err)
+	}
+
+	// A reset while recall is off is still remembered once it's back on
+	viper.Set("llm.recall.enabled", false)
+	err = Forget("reset")
+	if err != nil {
+		t.Fatal(err)
+	}
+	viper.Set("llm.recall.enabled", true)
+
+	count, _, err := Stats("reset")
+	if err != nil || count != 0 || len(Recalled("reset")) != 0 {
+		t.Errorf("%d messages & %d recollections left after forgetting, %v", count, len(Recalled("reset")), err)
+	}
+
+	// An index which can't be read is dropped all the same
+	path := filepath.Join(viper.GetString("llm.recall.dir"), fileName("corrupt"))
+	ioutil.WriteFile(path, []byte("garbage"), 0644)
+	if err = Forget("corrupt"); err != nil {
+		t.Errorf("forgetting a corrupt index failed: %v", err)
+	}
+	if _, err = os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("corrupt index left behind: %v", err)
+	}
+
+	// Nothing is written to forget a channel never remembered
+	dir := filepath.Join(t.TempDir(), "memory")
+	viper.Set("llm.recall.dir", dir)
+	if err = Forget("never"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("forgetting created %s: %v", dir, err)
+	}
+}
+
+func TestOpenReembedding(t *testing.T) {
+	viper.Set("llm.recall.enabled", true)
+	viper.Set("llm.recall.dir", t.TempDir())
+	defer viper.Set("llm.recall.enabled", false)
+	defer viper.Set("llm.recall.embedding", nil)
+
+	err := Remember("slow", context.ContextMessage{Id: "1", Author: context.Author{Id: "1", Name: "alice"}, Message: "hi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The embeddings endpoint answers once released
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var once sync.Once
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		once.Do(func() { close(started) })
+		<-release
+
+		json.NewEncoder(w).Encode(api.EmbeddingResponse{Data: []api.EmbeddingData{{Embedding: []float32{1, 0}}}})
+	}))
+	defer server.Close()
+
+	viper.Set("llm.recall.embedding", map[string]interface{}{"endpoint": server.URL, "model": "remote"})
+
+	reembedded := make(chan error)
+	go func() {
+		_, _, err := Stats("slow")
+		reembedded <- err
+	}()
+	<-started
+
+	// Other channels are opened while one is embedded again
+	opened := make(chan error)
+	go func() {
+		_, _, err := Stats("fast")
+		opened <- err
+	}()
+
+	select {
+	case err = <-opened:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		close(release)
+		t.Fatal("opening a channel waited on another being embedded again")
+	}
+
+	close(release)
+	if err = <-reembedded; err != nil {
+		t.Fatal(err)
+	}
+
+	_, embedder, _ := Stats("slow")
+	if embedder != "remote" {
+		t.Errorf("index embedded with %s", embedder)
+	}
+}
@@ -0,0 +1,195 @@
+package textgen
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/M-Ro/aurora-ai/internal/textgen/character"
+	chat "github.com/M-Ro/aurora-ai/internal/textgen/context"
+	"github.com/M-Ro/aurora-ai/internal/textgen/prompt"
+	"github.com/spf13/viper"
+)
+
+// stopFilter cuts a streamed reply at the first stop string, holding back any tail of
+// the reply which may yet turn into one so it is never shown.
+type stopFilter struct {
+	stops []string
+}
+
+func newStopFilter(stops []string) *stopFilter {
+	f := stopFilter{}
+	for _, stop := range stops {
+		if stop != "" {
+			f.stops = append(f.stops, stop)
+		}
+	}
+
+	return &f
+}
+
+// cut returns output up to the first stop string, and whether there was one.
+func (f *stopFilter) cut(output string) (string, bool) {
+	end := -1
+	for _, stop := range f.stops {
+		i := strings.Index(output, stop)
+		if i >= 0 && (end < 0 || i < end) {
+			end = i
+		}
+	}
+
+	if end < 0 {
+		return output, false
+	}
+
+	return output[:end], true
+}
+
+// apply returns the part of a partial reply which is safe to show, and whether a stop
+// string was hit, in which case the reply is final.
+func (f *stopFilter) apply(output string) (string, bool) {
+	output, stopped := f.cut(output)
+	if stopped {
+		return output, true
+	}
+
+	held := 0
+	for _, stop := range f.stops {
+		for n := len(stop) - 1; n > held; n-- {
+			if strings.HasSuffix(output, stop[:n]) {
+				held = n
+				break
+			}
+		}
+	}
+
+	return output[:len(output)-held], false
+}
+
+// stopStrings returns the strings which end the bot's turn in the conversation: those
+// configured by llm.settings.stopping_strings and by the prompt format, the speaker
+// identifiers and the name of every participant, as is and as labelled in the prompt,
+// starting a line.
+func stopStrings(chatCtx *chat.ChatContext, format *prompt.Format) []string {
+	stops := viper.GetStringSlice("llm.settings.stopping_strings")
+	if format != nil {
+		stops = append(stops, format.Stop...)
+	}
+
+	// The model doesn't always bother with the identifiers' trailing colon
+	for _, token := range []string{viper.GetString("llm.identifier_p"), character.BotToken()} {
+		identifier := strings.TrimRight(token, ":")
+		if identifier != "" {
+			stops = append(stops, "\n"+identifier)
+		}
+	}
+
+	if chatCtx != nil {
+		botToken := character.BotToken()
+		for _, ctxMsg := range chatCtx.Messages {
+			if ctxMsg.Author.Name != "" && ctxMsg.Author.Id != botToken {
+				stops = append(stops, "\n"+ctxMsg.Author.Name+":")
+			}
+		}
+
+		// As well as the names users are labelled with in the prompt
+		for _, name := range chatCtx.Speakers() {
+			stops = append(stops, "\n"+name+":")
+		}
+	}
+
+	seen := map[string]bool{}
+	unique := []string{}
+	for _, stop := range stops {
+		if !seen[stop] {
+			seen[stop] = true
+			unique = append(unique, stop)
+		}
+	}
+
+	return unique
+}
+
+// StopGenerator cuts the replies of Generator at the first stop string while they are
+// streamed, aborting the upstream job once one is hit, and emits the job's deltas from
+// what is left. Every generator returned by GetGenerator is wrapped in one.
+type StopGenerator struct {
+	Generator Generator
+	// Format is the prompt format of the generator, the default one if nil
+	Format *prompt.Format
+}
+
+func (g *StopGenerator) Generate(ctx context.Context, job *Job) (Usage, error) {
+	format := g.Format
+	if format == nil {
+		format = prompt.Default()
+	}
+
+	// Templates may add a good many tokens per message, so the conversation is sized in
+	// the layout it is sent in
+	if job.Context != nil {
+		job.Context.Format = format
+		job.Context.EnforceSize()
+	}
+
+	filter := newStopFilter(stopStrings(job.Context, format))
+
+	jobCtx, abort := context.WithCancel(ctx)
+	defer abort()
+
+	visible := ""
+	stopped := false
+	completed := false
+	deltas := deltaTracker{onDelta: job.OnDelta}
+
+	onUpdate := func(output string) {
+		deltas.update(output)
+		if job.OnUpdate != nil {
+			job.OnUpdate(output)
+		}
+	}
+	onComplete := func(output string) {
+		deltas.update(output)
+		job.OnComplete(output)
+	}
+
+	inner := *job
+	inner.OnUpdate = func(output string) {
+		if stopped {
+			return
+		}
+
+		visible, stopped = filter.apply(output)
+		if stopped {
+			abort()
+		}
+
+		onUpdate(visible)
+	}
+	inner.OnComplete = func(output string) {
+		if completed {
+			return
+		}
+
+		// Nothing more is coming, so a partial match is part of the reply after all
+		visible, _ = filter.cut(output)
+		stopped = true
+		completed = true
+
+		onComplete(visible)
+	}
+
+	usage, err := g.Generator.Generate(jobCtx, &inner)
+
+	// Our abort surfaces as the upstream job being cancelled
+	if stopped && ctx.Err() == nil && (err == nil || errors.Is(err, context.Canceled)) {
+		if !completed {
+			onComplete(visible)
+			usage = estimateUsage(job.Context, visible)
+		}
+
+		return usage, nil
+	}
+
+	return usage, err
+}
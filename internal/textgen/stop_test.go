@@ -0,0 +1,129 @@
+package textgen
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	chat "github.com/M-Ro/aurora-ai/internal/textgen/context"
+	"github.com/M-Ro/aurora-ai/internal/textgen/prompt"
+	"github.com/spf13/viper"
+)
+
+func TestStopFilter(t *testing.T) {
+	filter := newStopFilter([]string{"\n### Human:", "\nbob:"})
+
+	cases := []struct {
+		output  string
+		visible string
+		stopped bool
+	}{
+		{"Hello", "Hello", false},
+		{"Hello\n", "Hello", false},
+		{"Hello\n### Hu", "Hello", false},
+		{"Hello\nbo", "Hello", false},
+		{"Hello\nbob", "Hello", false},
+		{"Hello\nbobby", "Hello\nbobby", false},
+		{"Hello\nbob: hi\n### Human:", "Hello", true},
+		{"Hello\n### Human: hi", "Hello", true},
+	}
+
+	for _, c := range cases {
+		visible, stopped := filter.apply(c.output)
+		if visible != c.visible || stopped != c.stopped {
+			t.Errorf("apply(%q) = %q, %v, want %q, %v", c.output, visible, stopped, c.visible, c.stopped)
+		}
+	}
+}
+
+func TestStopGenerator(t *testing.T) {
+	viper.Set("llm.settings.maximum_prompt_tokens", 2048)
+
+	chatCtx := conversation("hello")
+	chatCtx.Messages[0].Author.Name = "bob"
+
+	fake := &FakeGenerator{Reply: "Sure thing!\nbob: thanks\nand then some more words"}
+	generator := &StopGenerator{Generator: fake}
+
+	updates := []string{}
+	completed := ""
+	_, err := generator.Generate(context.Background(), &Job{
+		Context:    chatCtx,
+		OnUpdate:   func(output string) { updates = append(updates, output) },
+		OnComplete: func(output string) { completed = output },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if completed != "Sure thing!" {
+		t.Errorf("completed with %q", completed)
+	}
+
+	for _, update := range updates {
+		if strings.Contains(update, "\n") {
+			t.Errorf("partial stop string shown in %q", update)
+		}
+	}
+
+	// The upstream job is abandoned once the stop string is hit
+	if len(updates) > 3 {
+		t.Errorf("generation continued after the stop string: %q", updates)
+	}
+}
+
+func TestStopGeneratorSize(t *testing.T) {
+	viper.Set("llm.context", "")
+	viper.Set("llm.identifier_p", "### Human:")
+	viper.Set("llm.identifier_b", "### Assistant:")
+	viper.Set("llm.settings.maximum_prompt_tokens", 30)
+	viper.Set("llm.prompt_formats.verbose", map[string]interface{}{
+		"user":   map[string]interface{}{"prefix": "<|turn|> the user says: ", "suffix": "\n"},
+		"primer": "<|turn|> the bot says:",
+	})
+	defer viper.Set("llm.settings.maximum_prompt_tokens", 2048)
+	defer viper.Set("llm.prompt_formats", map[string]interface{}{})
+
+	format, err := prompt.Get("verbose")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The conversation fits in the default format, but not in the generator's
+	chatCtx := &chat.ChatContext{}
+	for i := 0; i < 8; i++ {
+		chatCtx.AddMessage(&chat.ContextMessage{Author: chat.Author{Id: "1", Name: "user"}, Message: "hi"})
+	}
+	if len(chatCtx.Messages) != 8 {
+		t.Fatalf("kept %d messages in the default format", len(chatCtx.Messages))
+	}
+
+	generator := &StopGenerator{Generator: &FakeGenerator{Reply: testReply}, Format: format}
+	_, err = generator.Generate(context.Background(), &Job{Context: chatCtx, OnComplete: func(string) {}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(chatCtx.Messages) == 8 || chatCtx.TokenCount() > 30 {
+		t.Errorf("kept %d messages of %d tokens in the generator's format", len(chatCtx.Messages), chatCtx.TokenCount())
+	}
+}
+
+func TestStopStringsSpeakers(t *testing.T) {
+	chatCtx := conversation("hello")
+	chatCtx.Messages = append(chatCtx.Messages, chat.ContextMessage{
+		Author:  chat.Author{Id: "2", Name: "user"},
+		Message: "me too",
+	})
+
+	stops := map[string]bool{}
+	for _, stop := range stopStrings(chatCtx, nil) {
+		stops[stop] = true
+	}
+
+	for _, want := range []string{"\nuser:", "\nuser (2):"} {
+		if !stops[want] {
+			t.Errorf("%q missing from stop strings", want)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package textgen
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"github.com/M-Ro/aurora-ai/api"
+	"github.com/M-Ro/aurora-ai/internal/gradio"
+	"github.com/M-Ro/aurora-ai/internal/textgen/prompt"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// webuiApiSettings configures the connection to the webui's api extension. The blocking
+// & streaming apis are served on separate ports, 5000 & 5005 by default.
+type webuiApiSettings struct {
+	Host       string `mapstructure:"host"`
+	StreamHost string `mapstructure:"stream_host"`
+	// Stream tokens as they are generated rather than waiting for the whole reply
+	Stream bool `mapstructure:"stream"`
+}
+
+// webuiApiBackends holds a backend per generator & api host.
+var (
+	webuiApiMu       sync.Mutex
+	webuiApiBackends = map[string]*gradio.Backend{}
+)
+
+// WebuiApiGenerator uses text-generation-webui's api extension, configured by
+// <Key>.api. The api keeps no history, so there is no session to pick.
+type WebuiApiGenerator struct {
+	Key string
+}
+
+// getBackend returns the backend for host, which carries the scheme, tls & auth
+// settings under Key. The api has no login, so Gradio credentials are left out.
+func (g *WebuiApiGenerator) getBackend(host string) (*gradio.Backend, error) {
+	webuiApiMu.Lock()
+	defer webuiApiMu.Unlock()
+
+	cacheKey := g.Key + " " + host
+	backend, ok := webuiApiBackends[cacheKey]
+	if ok {
+		return backend, nil
+	}
+
+	tlsCfg := gradio.TLSConfig{}
+	err := viper.UnmarshalKey(g.Key+".tls", &tlsCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	auth := gradio.AuthConfig{}
+	err = viper.UnmarshalKey(g.Key+".auth", &auth)
+	if err != nil {
+		return nil, err
+	}
+	auth.Gradio = gradio.Credentials{}
+
+	backend, err = gradio.NewBackend(host, viper.GetString(g.Key+".scheme"), tlsCfg, auth)
+	if err != nil {
+		return nil, err
+	}
+
+	webuiApiBackends[cacheKey] = backend
+
+	return backend, nil
+}
+
+// Generate runs the job on the webui, streaming tokens over the websocket if <Key>.api.stream is set.
+func (g *WebuiApiGenerator) Generate(ctx context.Context, job *Job) (Usage, error) {
+	settings := webuiApiSettings{}
+	err := viper.UnmarshalKey(g.Key+".api", &settings)
+	if err != nil {
+		return Usage{}, err
+	}
+
+	params, err := getParameters()
+	if err != nil {
+		return Usage{}, err
+	}
+
+	format, err := prompt.ForKey(g.Key)
+	if err != nil {
+		return Usage{}, err
+	}
+
+	req := api.NewWebuiGenerateRequest(job.Context.PromptAs(format), params)
+
+	host := settings.Host
+	run := generateWebuiApi
+	if settings.Stream {
+		host = settings.StreamHost
+		run = streamWebuiApi
+	}
+
+	backend, err := g.getBackend(host)
+	if err != nil {
+		return Usage{}, err
+	}
+
+	output := ""
+	onComplete := func(reply string) {
+		output = reply
+		job.OnComplete(reply)
+	}
+
+	err = run(ctx, backend, &req, job.OnUpdate, onComplete)
+	if err != nil {
+		return Usage{}, err
+	}
+
+	return estimateUsage(job.Context, output), nil
+}
+
+// generateWebuiApi posts the request to /api/v1/generate, which answers with the whole
+// reply once generation is done.
+func generateWebuiApi(
+	ctx context.Context,
+	backend *gradio.Backend,
+	req *api.WebuiGenerateRequest,
+	onUpdate InferenceUpdateFunc,
+	onComplete InferenceCompleteFunc,
+) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	httpReq, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodPost,
+		backend.URL("/api/v1/generate"),
+		bytes.NewReader(body),
+	)
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	res, err := backend.HTTPClient().Do(httpReq)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		logrus.Error(err)
+		return gradio.ErrNoConnect
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return &gradio.UpstreamError{Message: res.Status}
+	}
+
+	generated := api.WebuiGenerateResponse{}
+	err = json.NewDecoder(res.Body).Decode(&generated)
+	if err != nil {
+		return &gradio.ProtocolError{Reason: "malformed generate response", Err: err}
+	}
+
+	if len(generated.Results) == 0 {
+		return &gradio.ProtocolError{Reason: "generate response has no results"}
+	}
+
+	output := generated.Results[0].Text
+	onUpdate(output)
+	onComplete(output)
+
+	return nil
+}
+
+// streamWebuiApi sends the request over the /api/v1/stream websocket, which answers
+// with each token as it is generated.
+func streamWebuiApi(
+	ctx context.Context,
+	backend *gradio.Backend,
+	req *api.WebuiGenerateRequest,
+	onUpdate InferenceUpdateFunc,
+	onComplete InferenceCompleteFunc,
+) error {
+	conn, _, err := backend.Dialer().DialContext(ctx, backend.WebsocketURL("/api/v1/stream"), backend.Header())
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		logrus.Error(err)
+		return gradio.ErrNoConnect
+	}
+	defer conn.Close()
+
+	// Unblock the read below if the job is cancelled, dropping the connection stops
+	// the webui generating
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	err = conn.WriteJSON(req)
+	if err != nil {
+		logrus.Error("ws write: ", err)
+		return gradio.ErrConnectionLost
+	}
+
+	output := ""
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			logrus.Error("ws read: ", err)
+			return gradio.ErrConnectionLost
+		}
+
+		logrus.Debug("ws recv: ", string(message))
+
+		packet := api.WebuiStreamPacket{}
+		err = json.Unmarshal(message, &packet)
+		if err != nil {
+			return &gradio.ProtocolError{Reason: "malformed stream packet", Err: err}
+		}
+
+		switch packet.Event {
+		case api.WebuiTextStream:
+			output += packet.Text
+			onUpdate(output)
+		case api.WebuiStreamEnd:
+			onComplete(output)
+			return nil
+		default:
+			return &gradio.ProtocolError{Reason: "unknown stream event " + string(packet.Event)}
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package textgen
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/M-Ro/aurora-ai/api"
+	"github.com/gorilla/websocket"
+	"github.com/spf13/viper"
+)
+
+// startFakeWebuiApi serves both the blocking & streaming apis, answering with testReply,
+// and points the llm config at it.
+func startFakeWebuiApi(t *testing.T, stream bool) *[]api.WebuiGenerateRequest {
+	requests := []api.WebuiGenerateRequest{}
+	upgrader := websocket.Upgrader{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/generate", func(w http.ResponseWriter, r *http.Request) {
+		req := api.WebuiGenerateRequest{}
+		json.NewDecoder(r.Body).Decode(&req)
+		requests = append(requests, req)
+
+		json.NewEncoder(w).Encode(api.WebuiGenerateResponse{
+			Results: []api.WebuiResult{{Text: testReply}},
+		})
+	})
+	mux.HandleFunc("/api/v1/stream", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		req := api.WebuiGenerateRequest{}
+		conn.ReadJSON(&req)
+		requests = append(requests, req)
+
+		for i, word := range strings.SplitAfter(testReply, " ") {
+			conn.WriteJSON(api.WebuiStreamPacket{Event: api.WebuiTextStream, MessageNum: i, Text: word})
+		}
+		conn.WriteJSON(api.WebuiStreamPacket{Event: api.WebuiStreamEnd})
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	host := server.Listener.Addr().String()
+	viper.Set("llm.backend", BackendWebuiApi)
+	viper.Set("llm.api", map[string]interface{}{"host": host, "stream_host": host, "stream": stream})
+	t.Cleanup(func() { viper.Set("llm.backend", nil) })
+
+	return &requests
+}
+
+func TestRunInferenceWebuiApi(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		name := "generate"
+		if stream {
+			name = "stream"
+		}
+
+		t.Run(name, func(t *testing.T) {
+			requests := startFakeWebuiApi(t, stream)
+
+			updates := []string{}
+			completed := ""
+
+			err := RunInference(
+				context.Background(),
+				t.Name(),
+				conversation("hello"),
+				nil,
+				func(output string) { updates = append(updates, output) },
+				func(output string) { completed = output },
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if completed != testReply {
+				t.Errorf("completed with %q, want %q", completed, testReply)
+			}
+
+			if len(updates) == 0 || updates[len(updates)-1] != testReply {
+				t.Errorf("unexpected updates %q", updates)
+			}
+
+			if len(*requests) != 1 || !strings.Contains((*requests)[0].Prompt, "hello") {
+				t.Errorf("unexpected requests %+v", *requests)
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package tokenizer
+
+import (
+	"strings"
+)
+
+// metaSpace is the character sentencepiece style tokenizers replace spaces with.
+const metaSpace = "▁"
+
+type mergePair struct {
+	left, right string
+}
+
+// bpe is a byte pair encoding tokenizer, merging pairs of symbols by rank as listed in
+// the tokenizer's merges. It covers both GPT-2 style byte level tokenizers and the
+// sentencepiece style ones Hugging Face converts llama models to.
+type bpe struct {
+	vocab map[string]int
+	ranks map[mergePair]int
+	added *addedTokens
+
+	// byteLevel maps each byte to a printable character before merging, as GPT-2 does
+	byteLevel bool
+	// byteFallback encodes symbols missing from the vocab as one token per byte
+	byteFallback bool
+	// ignoreMerges takes a word straight from the vocab if it is there
+	ignoreMerges bool
+	// split pre-tokenizes the text into words, which are merged apart
+	split func(text string) []string
+	// normalize is applied before splitting
+	normalize func(text string) string
+}
+
+func (t *bpe) Count(text string) (int, error) {
+	count := 0
+	for _, part := range t.added.split(text) {
+		if part.added {
+			count++
+			continue
+		}
+
+		text := part.text
+		if t.normalize != nil {
+			text = t.normalize(text)
+		}
+
+		words := []string{text}
+		if t.split != nil {
+			words = t.split(text)
+		}
+
+		for _, word := range words {
+			count += t.countWord(word)
+		}
+	}
+
+	return count, nil
+}
+
+func (t *bpe) countWord(word string) int {
+	if word == "" {
+		return 0
+	}
+
+	if t.byteLevel {
+		word = byteLevelEncode(word)
+	}
+
+	if t.ignoreMerges {
+		if _, ok := t.vocab[word]; ok {
+			return 1
+		}
+	}
+
+	symbols := []string{}
+	for _, r := range word {
+		symbols = append(symbols, string(r))
+	}
+
+	for len(symbols) > 1 {
+		best := -1
+		bestRank := 0
+		for i := 0; i < len(symbols)-1; i++ {
+			rank, ok := t.ranks[mergePair{symbols[i], symbols[i+1]}]
+			if ok && (best < 0 || rank < bestRank) {
+				best = i
+				bestRank = rank
+			}
+		}
+
+		if best < 0 {
+			break
+		}
+
+		symbols[best] += symbols[best+1]
+		symbols = append(symbols[:best+1], symbols[best+2:]...)
+	}
+
+	count := 0
+	for _, symbol := range symbols {
+		_, ok := t.vocab[symbol]
+		if !ok && t.byteFallback {
+			count += len(symbol)
+		} else {
+			count++
+		}
+	}
+
+	return count
+}
+
+var byteToRune = func() [256]rune {
+	var table [256]rune
+
+	n := 0
+	for b := 0; b < 256; b++ {
+		if (b >= '!' && b <= '~') || (b >= 0xA1 && b <= 0xAC) || (b >= 0xAE && b <= 0xFF) {
+			table[b] = rune(b)
+		} else {
+			table[b] = rune(256 + n)
+			n++
+		}
+	}
+
+	return table
+}()
+
+// byteLevelEncode maps every byte of s to the printable character GPT-2 uses for it.
+func byteLevelEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		b.WriteRune(byteToRune[s[i]])
+	}
+
+	return b.String()
+}
+
+// splitMetaSpace splits text before every meta space, as sentencepiece never merges
+// across words.
+func splitMetaSpace(text string) []string {
+	words := []string{}
+	start := 0
+	for i := range text {
+		if i > start && strings.HasPrefix(text[i:], metaSpace) {
+			words = append(words, text[start:i])
+			start = i
+		}
+	}
+
+	if start < len(text) {
+		words = append(words, text[start:])
+	}
+
+	return words
+}
+
+// toMetaSpace replaces spaces with meta spaces, optionally prepending one.
+func toMetaSpace(text string, prefix bool) string {
+	text = strings.ReplaceAll(text, " ", metaSpace)
+	if prefix && !strings.HasPrefix(text, metaSpace) {
+		text = metaSpace + text
+	}
+
+	return text
+}
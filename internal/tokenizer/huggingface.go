@@ -0,0 +1,208 @@
+package tokenizer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// hfTokenizer is the part of a Hugging Face tokenizer.json we make use of.
+type hfTokenizer struct {
+	AddedTokens []struct {
+		Content string `json:"content"`
+	} `json:"added_tokens"`
+	Normalizer   *hfComponent `json:"normalizer"`
+	PreTokenizer *hfComponent `json:"pre_tokenizer"`
+	Model        hfModel      `json:"model"`
+}
+
+// hfComponent is a normalizer or pre-tokenizer, Sequences nest further ones.
+type hfComponent struct {
+	Type          string         `json:"type"`
+	Normalizers   []*hfComponent `json:"normalizers"`
+	PreTokenizers []*hfComponent `json:"pretokenizers"`
+
+	// Replace
+	Pattern struct {
+		String string `json:"String"`
+	} `json:"pattern"`
+	Content string `json:"content"`
+	// Prepend
+	Prepend string `json:"prepend"`
+	// Metaspace
+	Replacement    string `json:"replacement"`
+	AddPrefixSpace *bool  `json:"add_prefix_space"`
+	PrependScheme  string `json:"prepend_scheme"`
+}
+
+// flatten returns the component and those nested in it.
+func (c *hfComponent) flatten() []*hfComponent {
+	if c == nil {
+		return nil
+	}
+
+	components := []*hfComponent{c}
+	for _, nested := range append(c.Normalizers, c.PreTokenizers...) {
+		components = append(components, nested.flatten()...)
+	}
+
+	return components
+}
+
+type hfModel struct {
+	Type         string          `json:"type"`
+	Vocab        json.RawMessage `json:"vocab"`
+	Merges       json.RawMessage `json:"merges"`
+	ByteFallback bool            `json:"byte_fallback"`
+	IgnoreMerges bool            `json:"ignore_merges"`
+}
+
+// LoadHuggingFace loads a Hugging Face tokenizer.json with a BPE or Unigram model.
+func LoadHuggingFace(path string) (Tokenizer, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseHuggingFace(data)
+}
+
+func parseHuggingFace(data []byte) (Tokenizer, error) {
+	hf := hfTokenizer{}
+	err := json.Unmarshal(data, &hf)
+	if err != nil {
+		return nil, err
+	}
+
+	added := []string{}
+	for _, token := range hf.AddedTokens {
+		added = append(added, token.Content)
+	}
+
+	normalize, split := hf.pipeline()
+
+	switch hf.Model.Type {
+	case "BPE":
+		vocab := map[string]int{}
+		err = json.Unmarshal(hf.Model.Vocab, &vocab)
+		if err != nil {
+			return nil, err
+		}
+
+		ranks, err := parseMerges(hf.Model.Merges)
+		if err != nil {
+			return nil, err
+		}
+
+		return &bpe{
+			vocab:        vocab,
+			ranks:        ranks,
+			added:        newAddedTokens(added),
+			byteLevel:    hf.byteLevel(),
+			byteFallback: hf.Model.ByteFallback,
+			ignoreMerges: hf.Model.IgnoreMerges,
+			normalize:    normalize,
+			split:        split,
+		}, nil
+	case "Unigram":
+		pieces := [][2]interface{}{}
+		err = json.Unmarshal(hf.Model.Vocab, &pieces)
+		if err != nil {
+			return nil, err
+		}
+
+		scores := map[string]float64{}
+		for _, p := range pieces {
+			piece, _ := p[0].(string)
+			score, _ := p[1].(float64)
+			scores[piece] = score
+		}
+
+		u := newUnigram(scores)
+		u.added = newAddedTokens(added)
+		u.byteFallback = hf.Model.ByteFallback
+		u.normalize = normalize
+		u.split = split
+
+		return u, nil
+	}
+
+	return nil, fmt.Errorf("Unsupported tokenizer model %q", hf.Model.Type)
+}
+
+// parseMerges reads the merges of a BPE model, listed either as "a b" strings or,
+// since tokenizers 0.20, as ["a", "b"] pairs.
+func parseMerges(data json.RawMessage) (map[mergePair]int, error) {
+	ranks := map[mergePair]int{}
+
+	asStrings := []string{}
+	if json.Unmarshal(data, &asStrings) == nil {
+		for rank, merge := range asStrings {
+			parts := strings.SplitN(merge, " ", 2)
+			if len(parts) == 2 {
+				ranks[mergePair{parts[0], parts[1]}] = rank
+			}
+		}
+
+		return ranks, nil
+	}
+
+	asPairs := [][2]string{}
+	err := json.Unmarshal(data, &asPairs)
+	if err != nil {
+		return nil, err
+	}
+
+	for rank, pair := range asPairs {
+		ranks[mergePair{pair[0], pair[1]}] = rank
+	}
+
+	return ranks, nil
+}
+
+func (hf *hfTokenizer) byteLevel() bool {
+	for _, c := range hf.PreTokenizer.flatten() {
+		if c.Type == "ByteLevel" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// pipeline returns the normalization & pre-tokenization of the tokenizer, covering the
+// byte level splitting of GPT-2 style tokenizers and the meta spaces of sentencepiece
+// style ones.
+func (hf *hfTokenizer) pipeline() (func(string) string, func(string) []string) {
+	replaceSpaces := false
+	prepend := false
+	for _, c := range hf.Normalizer.flatten() {
+		switch c.Type {
+		case "Replace":
+			replaceSpaces = replaceSpaces || (c.Pattern.String == " " && c.Content == metaSpace)
+		case "Prepend":
+			prepend = prepend || c.Prepend == metaSpace
+		}
+	}
+
+	var split func(string) []string
+	for _, c := range hf.PreTokenizer.flatten() {
+		switch c.Type {
+		case "ByteLevel", "Split":
+			split = splitByteLevel
+		case "Metaspace":
+			replaceSpaces = true
+			prepend = c.PrependScheme == "always" || c.PrependScheme == "first" ||
+				(c.PrependScheme == "" && (c.AddPrefixSpace == nil || *c.AddPrefixSpace))
+			split = splitMetaSpace
+		}
+	}
+
+	var normalize func(string) string
+	if replaceSpaces {
+		normalize = func(text string) string { return toMetaSpace(text, prepend) }
+	}
+
+	return normalize, split
+}
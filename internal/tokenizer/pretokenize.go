@@ -0,0 +1,146 @@
+package tokenizer
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// splitByteLevel splits text into words the way GPT-2's pre-tokenizer pattern does:
+//
+//	's|'t|'re|'ve|'m|'ll|'d| ?\p{L}+| ?\p{N}+| ?[^\s\p{L}\p{N}]+|\s+(?!\S)|\s+
+//
+// Go's regexp has no lookahead, so it is matched by hand.
+func splitByteLevel(text string) []string {
+	words := []string{}
+
+	for i := 0; i < len(text); {
+		n := matchWord(text[i:])
+		words = append(words, text[i:i+n])
+		i += n
+	}
+
+	return words
+}
+
+var contractions = []string{"'s", "'t", "'re", "'ve", "'m", "'ll", "'d"}
+
+// matchWord returns the length of the word at the start of s.
+func matchWord(s string) int {
+	for _, c := range contractions {
+		if len(s) >= len(c) && strings.EqualFold(s[:len(c)], c) {
+			return len(c)
+		}
+	}
+
+	start := 0
+	if s[0] == ' ' && len(s) > 1 {
+		r, _ := utf8.DecodeRuneInString(s[1:])
+		if !unicode.IsSpace(r) {
+			start = 1
+		}
+	}
+
+	r, _ := utf8.DecodeRuneInString(s[start:])
+	switch {
+	case unicode.IsLetter(r):
+		return start + runLength(s[start:], unicode.IsLetter)
+	case unicode.IsNumber(r):
+		return start + runLength(s[start:], unicode.IsNumber)
+	case !unicode.IsSpace(r):
+		return start + runLength(s[start:], func(r rune) bool {
+			return !unicode.IsSpace(r) && !unicode.IsLetter(r) && !unicode.IsNumber(r)
+		})
+	}
+
+	// A run of whitespace leaves its last character to the word following it
+	n := runLength(s, unicode.IsSpace)
+	if n == len(s) {
+		return n
+	}
+
+	_, size := utf8.DecodeLastRuneInString(s[:n])
+	if n > size {
+		return n - size
+	}
+
+	return n
+}
+
+// runLength returns the length of the run of runes matching f at the start of s.
+func runLength(s string, f func(rune) bool) int {
+	n := 0
+	for _, r := range s {
+		if !f(r) {
+			break
+		}
+		n += utf8.RuneLen(r)
+	}
+
+	return n
+}
+
+// addedTokens are tokens matched whole before the text is split, e.g <|im_start|>.
+type addedTokens struct {
+	// tokens are sorted longest first so the longest match wins
+	tokens []string
+}
+
+func newAddedTokens(tokens []string) *addedTokens {
+	a := addedTokens{}
+	for _, token := range tokens {
+		if token != "" {
+			a.tokens = append(a.tokens, token)
+		}
+	}
+
+	sort.SliceStable(a.tokens, func(i, j int) bool {
+		return len(a.tokens[i]) > len(a.tokens[j])
+	})
+
+	return &a
+}
+
+type textPart struct {
+	text  string
+	added bool
+}
+
+// split cuts text into runs of plain text and added tokens.
+func (a *addedTokens) split(text string) []textPart {
+	if a == nil || len(a.tokens) == 0 {
+		return []textPart{{text: text}}
+	}
+
+	parts := []textPart{}
+	start := 0
+	for i := 0; i < len(text); {
+		matched := ""
+		for _, token := range a.tokens {
+			if strings.HasPrefix(text[i:], token) {
+				matched = token
+				break
+			}
+		}
+
+		if matched == "" {
+			i++
+			continue
+		}
+
+		if i > start {
+			parts = append(parts, textPart{text: text[start:i]})
+		}
+		parts = append(parts, textPart{text: matched, added: true})
+
+		i += len(matched)
+		start = i
+	}
+
+	if start < len(text) {
+		parts = append(parts, textPart{text: text[start:]})
+	}
+
+	return parts
+}
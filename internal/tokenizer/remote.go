@@ -0,0 +1,101 @@
+package tokenizer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/M-Ro/aurora-ai/api"
+)
+
+// Kinds of token counting endpoint.
+const (
+	// EndpointWebui is text-generation-webui's /api/v1/token-count
+	EndpointWebui = "webui"
+	// EndpointLlamaCpp is llama.cpp server's /tokenize
+	EndpointLlamaCpp = "llamacpp"
+)
+
+const remoteTimeout = 10 * time.Second
+
+// Remote counts tokens by asking the backend serving the model.
+type Remote struct {
+	// URL of the endpoint, e.g http://127.0.0.1:5000/api/v1/token-count
+	URL  string
+	Kind string
+
+	client http.Client
+}
+
+func NewRemote(url string, kind string) (*Remote, error) {
+	if kind == "" {
+		kind = EndpointWebui
+	}
+
+	if kind != EndpointWebui && kind != EndpointLlamaCpp {
+		return nil, fmt.Errorf("Unknown token count endpoint type %q", kind)
+	}
+
+	if !strings.Contains(url, "://") {
+		url = "http://" + url
+	}
+
+	return &Remote{
+		URL:    url,
+		Kind:   kind,
+		client: http.Client{Timeout: remoteTimeout},
+	}, nil
+}
+
+func (r *Remote) Count(text string) (int, error) {
+	if r.Kind == EndpointLlamaCpp {
+		res := api.LlamaCppTokenizeResponse{}
+		err := r.post(&api.LlamaCppTokenizeRequest{Content: text}, &res)
+		if err != nil {
+			return 0, err
+		}
+
+		return len(res.Tokens), nil
+	}
+
+	res := api.WebuiTokenCountResponse{}
+	err := r.post(&api.WebuiTokenCountRequest{Prompt: text}, &res)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(res.Results) == 0 {
+		return 0, fmt.Errorf("Token count response from %s has no results", r.URL)
+	}
+
+	return res.Results[0].Tokens, nil
+}
+
+func (r *Remote) post(body interface{}, response interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, r.URL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("Token count request to %s failed: %s", r.URL, res.Status)
+	}
+
+	return json.NewDecoder(res.Body).Decode(response)
+}
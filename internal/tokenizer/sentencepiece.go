@@ -0,0 +1,242 @@
+package tokenizer
+
+import (
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"math"
+)
+
+var (
+	ErrMalformedModel = errors.New("Malformed sentencepiece model")
+)
+
+// Types of sentencepiece model, from the trainer spec.
+const (
+	spModelUnigram = 1
+	spModelBPE     = 2
+)
+
+// Types of sentencepiece piece.
+const (
+	spPieceNormal      = 1
+	spPieceUnknown     = 2
+	spPieceControl     = 3
+	spPieceUserDefined = 4
+	spPieceUnused      = 5
+	spPieceByte        = 6
+)
+
+// spBPE is sentencepiece's flavour of byte pair encoding, which merges the pair making
+// the highest scoring piece rather than going by a list of merges.
+type spBPE struct {
+	scores         map[string]float64
+	added          *addedTokens
+	byteFallback   bool
+	addDummyPrefix bool
+}
+
+func (t *spBPE) Count(text string) (int, error) {
+	count := 0
+	for _, part := range t.added.split(text) {
+		if part.added {
+			count++
+			continue
+		}
+
+		for _, word := range splitMetaSpace(toMetaSpace(part.text, t.addDummyPrefix)) {
+			count += t.countWord(word)
+		}
+	}
+
+	return count, nil
+}
+
+func (t *spBPE) countWord(word string) int {
+	symbols := []string{}
+	for _, r := range word {
+		symbols = append(symbols, string(r))
+	}
+
+	for len(symbols) > 1 {
+		best := -1
+		bestScore := 0.0
+		for i := 0; i < len(symbols)-1; i++ {
+			score, ok := t.scores[symbols[i]+symbols[i+1]]
+			if ok && (best < 0 || score > bestScore) {
+				best = i
+				bestScore = score
+			}
+		}
+
+		if best < 0 {
+			break
+		}
+
+		symbols[best] += symbols[best+1]
+		symbols = append(symbols[:best+1], symbols[best+2:]...)
+	}
+
+	count := 0
+	for _, symbol := range symbols {
+		_, ok := t.scores[symbol]
+		if !ok && t.byteFallback {
+			count += len(symbol)
+		} else {
+			count++
+		}
+	}
+
+	return count
+}
+
+// LoadSentencePiece loads a sentencepiece tokenizer.model, such as llama's.
+func LoadSentencePiece(path string) (Tokenizer, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseSentencePiece(data)
+}
+
+// parseSentencePiece decodes the ModelProto protobuf of a sentencepiece model, reading
+// only the fields needed to tokenize.
+func parseSentencePiece(data []byte) (Tokenizer, error) {
+	scores := map[string]float64{}
+	userDefined := []string{}
+	modelType := uint64(spModelUnigram)
+	byteFallback := false
+	addDummyPrefix := true
+
+	err := readProto(data, func(field int, value []byte, varint uint64) error {
+		switch field {
+		case 1: // pieces
+			piece := ""
+			score := 0.0
+			pieceType := uint64(spPieceNormal)
+
+			err := readProto(value, func(field int, value []byte, varint uint64) error {
+				switch field {
+				case 1:
+					piece = string(value)
+				case 2:
+					if len(value) != 4 {
+						return ErrMalformedModel
+					}
+					score = float64(math.Float32frombits(binary.LittleEndian.Uint32(value)))
+				case 3:
+					pieceType = varint
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			switch pieceType {
+			case spPieceNormal:
+				scores[piece] = score
+			case spPieceUserDefined:
+				userDefined = append(userDefined, piece)
+			case spPieceByte:
+				byteFallback = true
+			}
+		case 2: // trainer_spec
+			return readProto(value, func(field int, value []byte, varint uint64) error {
+				switch field {
+				case 3:
+					modelType = varint
+				case 35:
+					byteFallback = byteFallback || varint != 0
+				}
+				return nil
+			})
+		case 3: // normalizer_spec
+			return readProto(value, func(field int, value []byte, varint uint64) error {
+				if field == 3 {
+					addDummyPrefix = varint != 0
+				}
+				return nil
+			})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(scores) == 0 {
+		return nil, ErrMalformedModel
+	}
+
+	added := newAddedTokens(userDefined)
+
+	if modelType == spModelBPE {
+		return &spBPE{
+			scores:         scores,
+			added:          added,
+			byteFallback:   byteFallback,
+			addDummyPrefix: addDummyPrefix,
+		}, nil
+	}
+
+	u := newUnigram(scores)
+	u.added = added
+	u.byteFallback = byteFallback
+	u.normalize = func(text string) string { return toMetaSpace(text, addDummyPrefix) }
+	u.split = splitMetaSpace
+
+	return u, nil
+}
+
+// readProto calls onField with each field of a protobuf message. Length delimited
+// and fixed width fields are passed as value, varints as varint.
+func readProto(data []byte, onField func(field int, value []byte, varint uint64) error) error {
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return ErrMalformedModel
+		}
+		data = data[n:]
+
+		field := int(key >> 3)
+		var value []byte
+		var varint uint64
+
+		switch key & 7 {
+		case 0:
+			varint, n = binary.Uvarint(data)
+			if n <= 0 {
+				return ErrMalformedModel
+			}
+			data = data[n:]
+		case 1:
+			if len(data) < 8 {
+				return ErrMalformedModel
+			}
+			value, data = data[:8], data[8:]
+		case 2:
+			length, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < length {
+				return ErrMalformedModel
+			}
+			value, data = data[n:n+int(length)], data[n+int(length):]
+		case 5:
+			if len(data) < 4 {
+				return ErrMalformedModel
+			}
+			value, data = data[:4], data[4:]
+		default:
+			return ErrMalformedModel
+		}
+
+		err := onField(field, value, varint)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,144 @@
+// Package tokenizer counts tokens the way the model does, so the chat context can be
+// sized to the model's real limit.
+package tokenizer
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// Tokenizer counts the tokens text encodes to.
+type Tokenizer interface {
+	Count(text string) (int, error)
+}
+
+// Settings are read from llm.tokenizer.
+type Settings struct {
+	// File is a Hugging Face tokenizer.json or a sentencepiece tokenizer.model
+	File string `mapstructure:"file"`
+	// Endpoint counts tokens on the backend when no File is set
+	Endpoint     string `mapstructure:"endpoint"`
+	EndpointType string `mapstructure:"endpoint_type"`
+}
+
+// Load loads the tokenizer at path, a tokenizer.json or tokenizer.model.
+func Load(path string) (Tokenizer, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		return parseHuggingFace(data)
+	}
+
+	return parseSentencePiece(data)
+}
+
+// maxCached bounds the counts kept by Count, which are dropped once it's reached.
+const maxCached = 4096
+
+// failureBackoff is how long words are counted instead after the tokenizer fails, so
+// an unreachable endpoint isn't waited on for every message.
+const failureBackoff = time.Minute
+
+var (
+	mu        sync.Mutex
+	loaded    Tokenizer
+	signature string
+	cache     = map[string]int{}
+	// failedUntil is when the tokenizer is tried again after failing
+	failedUntil time.Time
+)
+
+// Count returns the token count of text with the configured tokenizer, falling back to
+// the backend's endpoint and then to counting words if neither is available or the
+// tokenizer failed recently.
+func Count(text string) int {
+	t := get()
+
+	mu.Lock()
+	count, ok := cache[text]
+	failing := time.Now().Before(failedUntil)
+	mu.Unlock()
+	if ok {
+		return count
+	}
+
+	if t == nil || failing {
+		return len(strings.Fields(text))
+	}
+
+	count, err := t.Count(text)
+	if err != nil {
+		logrus.Warnf("Counting tokens failed, counting words instead for %s: %v", failureBackoff, err)
+
+		mu.Lock()
+		failedUntil = time.Now().Add(failureBackoff)
+		mu.Unlock()
+
+		return len(strings.Fields(text))
+	}
+
+	mu.Lock()
+	if len(cache) >= maxCached {
+		cache = map[string]int{}
+	}
+	cache[text] = count
+	mu.Unlock()
+
+	return count
+}
+
+// get returns the configured tokenizer, reloading it if the settings changed.
+func get() Tokenizer {
+	settings := Settings{}
+	err := viper.UnmarshalKey("llm.tokenizer", &settings)
+	if err != nil {
+		logrus.Error(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	s := settings.File + " " + settings.Endpoint + " " + settings.EndpointType
+	if s == signature {
+		return loaded
+	}
+
+	signature = s
+	loaded = nil
+	cache = map[string]int{}
+	failedUntil = time.Time{}
+
+	if settings.File != "" {
+		t, err := Load(settings.File)
+		if err == nil {
+			logrus.Infof("Loaded tokenizer %s", settings.File)
+			loaded = t
+			return loaded
+		}
+
+		logrus.Errorf("Loading tokenizer %s failed: %v", settings.File, err)
+	}
+
+	if settings.Endpoint != "" {
+		t, err := NewRemote(settings.Endpoint, settings.EndpointType)
+		if err == nil {
+			loaded = t
+			return loaded
+		}
+
+		logrus.Error(err)
+	}
+
+	logrus.Warn("No tokenizer configured, token counts are estimated from word counts")
+
+	return nil
+}
@@ -0,0 +1,219 @@
+package tokenizer
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/M-Ro/aurora-ai/api"
+	"github.com/spf13/viper"
+)
+
+const testTokenizerJSON = `{
+	"added_tokens": [{"id": 100, "content": "<|im_start|>"}],
+	"normalizer": null,
+	"pre_tokenizer": {"type": "ByteLevel", "add_prefix_space": false},
+	"model": {
+		"type": "BPE",
+		"vocab": {"h": 0, "e": 1, "l": 2, "o": 3, "w": 4, "r": 5, "d": 6, "Ġ": 7,
+			"he": 8, "ll": 9, "hell": 10, "hello": 11, "Ġw": 12, "or": 13, "Ġwor": 14},
+		"merges": ["h e", "l l", "he ll", "hell o", "Ġ w", "o r", "Ġw or"]
+	}
+}`
+
+func TestSplitByteLevel(t *testing.T) {
+	cases := map[string][]string{
+		"hello world":     {"hello", " world"},
+		"it's 42!":        {"it", "'s", " 42", "!"},
+		"a  b":            {"a", " ", " b"},
+		"trailing   ":     {"trailing", "   "},
+		"line\nbreak":     {"line", "\n", "break"},
+		"ünïcode wörds ☺": {"ünïcode", " wörds", " ☺"},
+	}
+
+	for text, expected := range cases {
+		words := splitByteLevel(text)
+		if !reflect.DeepEqual(words, expected) {
+			t.Errorf("splitByteLevel(%q) = %q, expected %q", text, words, expected)
+		}
+	}
+}
+
+func TestHuggingFaceBPE(t *testing.T) {
+	tok, err := parseHuggingFace([]byte(testTokenizerJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]int{
+		"hello":                   1,
+		"hello world":             4,
+		"<|im_start|>hello world": 5,
+		"":                        0,
+	}
+
+	for text, expected := range cases {
+		count, err := tok.Count(text)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if count != expected {
+			t.Errorf("Count(%q) = %d, expected %d", text, count, expected)
+		}
+	}
+}
+
+// protoField encodes a length delimited protobuf field.
+func protoField(field int, value []byte) []byte {
+	buf := make([]byte, binary.MaxVarintLen64)
+
+	data := append([]byte{}, buf[:binary.PutUvarint(buf, uint64(field<<3|2))]...)
+	data = append(data, buf[:binary.PutUvarint(buf, uint64(len(value)))]...)
+	return append(data, value...)
+}
+
+func spPiece(piece string, score float32) []byte {
+	value := protoField(1, []byte(piece))
+	score32 := make([]byte, 4)
+	binary.LittleEndian.PutUint32(score32, math.Float32bits(score))
+
+	value = append(value, byte(2<<3|5))
+	value = append(value, score32...)
+	return protoField(1, value)
+}
+
+func TestSentencePieceUnigram(t *testing.T) {
+	model := []byte{}
+	model = append(model, spPiece("▁hello", -1)...)
+	model = append(model, spPiece("▁", -2)...)
+	for _, c := range []string{"h", "e", "l", "o"} {
+		model = append(model, spPiece(c, -5)...)
+	}
+
+	path := filepath.Join(t.TempDir(), "tokenizer.model")
+	err := ioutil.WriteFile(path, model, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tok, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ▁hello ▁ h i, with i unknown
+	count, err := tok.Count("hello hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count != 4 {
+		t.Errorf("Got %d tokens, expected 4", count)
+	}
+
+	_, err = parseSentencePiece([]byte{0xff})
+	if err != ErrMalformedModel {
+		t.Errorf("Expected ErrMalformedModel, got %v", err)
+	}
+}
+
+func TestRemote(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/token-count":
+			req := api.WebuiTokenCountRequest{}
+			json.NewDecoder(r.Body).Decode(&req)
+			json.NewEncoder(w).Encode(api.WebuiTokenCountResponse{
+				Results: []api.WebuiTokenCount{{Tokens: len(req.Prompt)}},
+			})
+		case "/tokenize":
+			req := api.LlamaCppTokenizeRequest{}
+			json.NewDecoder(r.Body).Decode(&req)
+			json.NewEncoder(w).Encode(api.LlamaCppTokenizeResponse{
+				Tokens: make([]int, len(req.Content)),
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	for kind, path := range map[string]string{
+		EndpointWebui:    "/api/v1/token-count",
+		EndpointLlamaCpp: "/tokenize",
+	} {
+		remote, err := NewRemote(server.URL+path, kind)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		count, err := remote.Count("hello")
+		if err != nil {
+			t.Fatalf("%s: %v", kind, err)
+		}
+
+		if count != 5 {
+			t.Errorf("%s: got %d tokens, expected 5", kind, count)
+		}
+	}
+
+	remote, err := NewRemote(server.URL+"/missing", EndpointWebui)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = remote.Count("hello")
+	if err == nil {
+		t.Error("Expected an error from a missing endpoint")
+	}
+}
+
+func TestCount(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokenizer.json")
+	err := ioutil.WriteFile(path, []byte(testTokenizerJSON), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	viper.Set("llm.tokenizer.file", "")
+	if count := Count("hello world"); count != 2 {
+		t.Errorf("Without a tokenizer got %d, expected the 2 words", count)
+	}
+
+	viper.Set("llm.tokenizer.file", path)
+	defer viper.Set("llm.tokenizer.file", "")
+
+	if count := Count("hello world"); count != 4 {
+		t.Errorf("Got %d tokens, expected 4", count)
+	}
+}
+
+func TestCountBackoff(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		http.Error(w, "loading model", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	viper.Set("llm.tokenizer.endpoint", server.URL)
+	defer viper.Set("llm.tokenizer.endpoint", "")
+
+	for _, text := range []string{"hello world", "how are you", "fine thanks"} {
+		if count := Count(text); count != len(strings.Fields(text)) {
+			t.Errorf("Got %d for %q, expected its words", count, text)
+		}
+	}
+
+	if requests != 1 {
+		t.Errorf("Endpoint asked %d times after failing, expected once", requests)
+	}
+}
@@ -0,0 +1,118 @@
+package tokenizer
+
+import (
+	"math"
+	"unicode/utf8"
+)
+
+// unigram is a unigram language model tokenizer, picking the split of each word whose
+// pieces have the highest total score.
+type unigram struct {
+	scores map[string]float64
+	added  *addedTokens
+	// maxLen is the length in runes of the longest piece
+	maxLen int
+	// unkScore is the score of a character missing from the vocab
+	unkScore float64
+
+	byteFallback bool
+	split        func(text string) []string
+	normalize    func(text string) string
+}
+
+func newUnigram(scores map[string]float64) *unigram {
+	u := unigram{
+		scores: scores,
+	}
+
+	minScore := 0.0
+	for piece, score := range scores {
+		if n := utf8.RuneCountInString(piece); n > u.maxLen {
+			u.maxLen = n
+		}
+
+		minScore = math.Min(minScore, score)
+	}
+
+	// As sentencepiece does, make unknown characters the last resort
+	u.unkScore = minScore - 10
+
+	return &u
+}
+
+func (t *unigram) Count(text string) (int, error) {
+	count := 0
+	for _, part := range t.added.split(text) {
+		if part.added {
+			count++
+			continue
+		}
+
+		text := part.text
+		if t.normalize != nil {
+			text = t.normalize(text)
+		}
+
+		words := []string{text}
+		if t.split != nil {
+			words = t.split(text)
+		}
+
+		for _, word := range words {
+			count += t.countWord(word)
+		}
+	}
+
+	return count, nil
+}
+
+// countWord finds the best split of word with the viterbi algorithm.
+func (t *unigram) countWord(word string) int {
+	runes := []rune(word)
+	n := len(runes)
+
+	best := make([]float64, n+1)
+	tokens := make([]int, n+1)
+	for i := 1; i <= n; i++ {
+		best[i] = math.Inf(-1)
+	}
+
+	for i := 0; i < n; i++ {
+		if math.IsInf(best[i], -1) {
+			continue
+		}
+
+		known := false
+		for l := 1; l <= t.maxLen && i+l <= n; l++ {
+			score, ok := t.scores[string(runes[i:i+l])]
+			if !ok {
+				continue
+			}
+
+			if l == 1 {
+				known = true
+			}
+
+			if best[i]+score > best[i+l] {
+				best[i+l] = best[i] + score
+				tokens[i+l] = tokens[i] + 1
+			}
+		}
+
+		if known {
+			continue
+		}
+
+		unkTokens := 1
+		if t.byteFallback {
+			unkTokens = utf8.RuneLen(runes[i])
+		}
+
+		if best[i]+t.unkScore > best[i+1] {
+			best[i+1] = best[i] + t.unkScore
+			tokens[i+1] = tokens[i] + unkTokens
+		}
+	}
+
+	return tokens[n]
+}